@@ -0,0 +1,61 @@
+package procrastiproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mode selects what the proxy refuses during a block window
+type Mode string
+
+const (
+	// ModeDeny refuses requests to hosts on the block list, and permits everything else. It is the default
+	ModeDeny Mode = "deny"
+	// ModeAllow, or focus mode, refuses requests to every host that isn't on the allow list
+	ModeAllow Mode = "allow"
+)
+
+type InvalidModeError struct {
+	Value string
+}
+
+func (err InvalidModeError) Error() string {
+	return fmt.Sprintf("Invalid mode {%s} passed with flag {mode}. Mode must be %s or %s", err.Value, ModeDeny, ModeAllow)
+}
+
+// parseMode validates the value of the --mode flag. An empty value selects ModeDeny
+func parseMode(s string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", ModeDeny:
+		return ModeDeny, nil
+	case ModeAllow:
+		return ModeAllow, nil
+	}
+	return "", InvalidModeError{Value: s}
+}
+
+// GetAllowList returns the hosts that are permitted during a block window. In deny mode, they are exempted from the
+// block list, and in allow mode, they are the only hosts permitted
+func (p *Procrastiproxy) GetAllowList() *List {
+	return p.AllowList
+}
+
+// GetMode returns the proxy's Mode, which is ModeDeny unless it has been set otherwise
+func (p *Procrastiproxy) GetMode() Mode {
+	if p.Mode == "" {
+		return ModeDeny
+	}
+	return p.Mode
+}
+
+// Allow adds host to the allow list, persisting the change to the proxy's Store, if it has one
+func (p *Procrastiproxy) Allow(host string) error {
+	_, err := p.changeAllowList(host, true)
+	return err
+}
+
+// Disallow removes host from the allow list, persisting the change to the proxy's Store, if it has one
+func (p *Procrastiproxy) Disallow(host string) error {
+	_, err := p.changeAllowList(host, false)
+	return err
+}
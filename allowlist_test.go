@@ -0,0 +1,144 @@
+package procrastiproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	testCases := []struct {
+		Value   string
+		Want    Mode
+		WantErr bool
+	}{
+		{Value: "", Want: ModeDeny},
+		{Value: "deny", Want: ModeDeny},
+		{Value: "Allow", Want: ModeAllow},
+		{Value: " allow ", Want: ModeAllow},
+		{Value: "focus", WantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Value, func(t *testing.T) {
+			mode, err := parseMode(tc.Value)
+			if tc.WantErr {
+				require.Equal(t, InvalidModeError{Value: tc.Value}, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.Want, mode)
+		})
+	}
+}
+
+// TestAllowListPrecedence checks every combination of mode and list membership, during and outside the block window
+func TestAllowListPrecedence(t *testing.T) {
+	duringWindow := time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC)
+	afterWindow := time.Date(2022, time.June, 6, 20, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		Name        string
+		Mode        Mode
+		Host        string
+		Now         time.Time
+		WantBlocked bool
+		WantRule    string
+	}{
+		{Name: "Deny mode, blocked host", Mode: ModeDeny, Host: "reddit.com", Now: duringWindow, WantBlocked: true, WantRule: "block list: reddit.com"},
+		{Name: "Deny mode, allowed subdomain of a blocked host", Mode: ModeDeny, Host: "docs.reddit.com", Now: duringWindow, WantRule: "allow list: docs.reddit.com"},
+		{Name: "Deny mode, host on both lists", Mode: ModeDeny, Host: "github.com", Now: duringWindow, WantRule: "allow list: github.com"},
+		{Name: "Deny mode, host on neither list", Mode: ModeDeny, Host: "example.com", Now: duringWindow},
+		{Name: "Allow mode, allowed host", Mode: ModeAllow, Host: "ci.example.com", Now: duringWindow, WantRule: "allow list: ci.example.com"},
+		{Name: "Allow mode, host on both lists", Mode: ModeAllow, Host: "github.com", Now: duringWindow, WantRule: "allow list: github.com"},
+		{Name: "Allow mode, host on neither list", Mode: ModeAllow, Host: "example.com", Now: duringWindow, WantBlocked: true, WantRule: "allow mode"},
+		{Name: "Allow mode, outside the block window", Mode: ModeAllow, Host: "example.com", Now: afterWindow},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy(WithMode(tc.Mode))
+			AddHostToBlockList(p.GetList(), "reddit.com", "github.com")
+			AddHostToBlockList(p.GetAllowList(), "docs.reddit.com", "github.com", "ci.example.com")
+
+			r := httptest.NewRequest(http.MethodGet, "http://"+tc.Host+"/", nil)
+			d := p.DefaultPolicy().Decide(context.Background(), r, tc.Now)
+			require.Equal(t, tc.WantBlocked, d.Blocked)
+			require.Equal(t, tc.WantRule, d.Rule)
+		})
+	}
+}
+
+// TestNilAllowList ensures that a proxy without an allow list treats it as empty rather than panicking
+func TestNilAllowList(t *testing.T) {
+	duringWindow := time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC)
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	p := NewProcrastiproxy(WithAllowList(nil), WithMode(ModeAllow))
+	require.NotNil(t, p.GetAllowList())
+	require.NoError(t, p.Allow("docs.reddit.com"))
+	require.True(t, p.DefaultPolicy().Decide(context.Background(), r, duringWindow).Blocked)
+
+	p = &Procrastiproxy{List: NewList(), Mode: ModeAllow}
+	d := p.DefaultPolicy().Decide(context.Background(), r, duringWindow)
+	require.True(t, d.Blocked)
+	require.Equal(t, "allow mode", d.Rule)
+}
+
+func TestApplyConfigAllowMode(t *testing.T) {
+	c := DefaultConfig()
+	c.Mode = "allow"
+	c.Allow = []string{"docs.github.com", "ci.example.com"}
+
+	// The block list may be empty in allow mode
+	p := NewProcrastiproxy()
+	require.NoError(t, p.ApplyConfig(c))
+	require.Equal(t, ModeAllow, p.GetMode())
+	require.True(t, p.GetAllowList().Contains("docs.github.com"))
+	require.Equal(t, 0, p.GetList().Length())
+
+	c.Mode = "focus"
+	err := p.ApplyConfig(c)
+	require.Error(t, err)
+	require.Len(t, configProblems(err), 2)
+}
+
+func TestAPIAllowList(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	p := NewProcrastiproxy(WithMode(ModeAllow), WithStore(NewFileStore(statePath)))
+
+	w := serveAPI(t, p, http.MethodPost, "/api/v1/allowlist", `{"host": "Docs.GitHub.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "/api/v1/allowlist/docs.github.com", w.Header().Get("Location"))
+
+	w = serveAPI(t, p, http.MethodPost, "/api/v1/allowlist", `{"host": "docs.github.com"}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.JSONEq(t, `{"error": "docs.github.com is already on the allow list"}`, w.Body.String())
+
+	w = serveAPI(t, p, http.MethodGet, "/api/v1/allowlist", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"hosts": ["docs.github.com"]}`, w.Body.String())
+
+	// The allow list is kept apart from the block list
+	require.Equal(t, 0, p.GetList().Length())
+
+	// Allow list changes are saved along with block list changes
+	s, err := p.Store.Load()
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs.github.com"}, s.Allowed)
+
+	w = serveAPI(t, p, http.MethodDelete, "/api/v1/allowlist/docs.github.com", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.False(t, p.GetAllowList().Contains("docs.github.com"))
+
+	w = serveAPI(t, p, http.MethodDelete, "/api/v1/allowlist/docs.github.com", "")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	s, err = p.Store.Load()
+	require.NoError(t, err)
+	require.Empty(t, s.Allowed)
+	require.Equal(t, []string{"docs.github.com"}, s.Disallowed)
+}
@@ -0,0 +1,376 @@
+package procrastiproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// apiPrefix is the path that version 1 of the JSON admin API is served under
+const apiPrefix = "/api/v1/"
+
+// maxAPIBodyBytes caps the size of request bodies the admin API will read
+const maxAPIBodyBytes = 1 << 20
+
+// maxImportBodyBytes caps the size of the files that may be imported into the block and allow lists, which is larger
+// than for other requests, as published block lists run to several megabytes
+const maxImportBodyBytes = 32 << 20
+
+// APIError is the body of every unsuccessful admin API response
+type APIError struct {
+	Error string `json:"error"`
+	// Problems lists each invalid setting when a request is rejected for more than one reason
+	Problems []string `json:"problems,omitempty"`
+}
+
+// BlockListResponse is the body of GET /api/v1/blocklist and GET /api/v1/allowlist
+type BlockListResponse struct {
+	Hosts []string `json:"hosts"`
+}
+
+// BlockListEntry is the body of POST /api/v1/blocklist and POST /api/v1/allowlist, and of their responses
+type BlockListEntry struct {
+	Host string `json:"host"`
+}
+
+// ImportResponse is the body of POST /api/v1/blocklist/import and POST /api/v1/allowlist/import
+type ImportResponse struct {
+	// Imported is the number of distinct hosts the file listed
+	Imported int `json:"imported"`
+	// Added is the number of those hosts that weren't already on the list
+	Added int `json:"added"`
+}
+
+// ScheduleDocument describes when the block list applies. It is the body of GET and PUT /api/v1/schedule, and uses
+// the same formats as the config file
+type ScheduleDocument struct {
+	Timezone       string   `json:"timezone"`
+	BlockStartTime string   `json:"block_start_time"`
+	BlockEndTime   string   `json:"block_end_time"`
+	BlockWindows   []string `json:"block_windows,omitempty"`
+	Schedule       []string `json:"schedule,omitempty"`
+}
+
+// StatusResponse is the body of GET /api/v1/status
+type StatusResponse struct {
+	Time          string `json:"time"`
+	InBlockWindow bool   `json:"in_block_window"`
+	Window        string `json:"window,omitempty"`
+	BlockedHosts  int    `json:"blocked_hosts"`
+	AllowedHosts  int    `json:"allowed_hosts"`
+	Mode          Mode   `json:"mode"`
+}
+
+// hostList describes a list of hosts that the admin API manages
+type hostList struct {
+	// name is how the list is described in messages, e.g., block list
+	name string
+	// resource is the path the list is served under, e.g., blocklist
+	resource string
+	list     func() *List
+	change   func(host string, add bool) (bool, error)
+	// importHosts adds many hosts to the list at once
+	importHosts func(hosts []string) (int, error)
+}
+
+func (p *Procrastiproxy) apiHostLists() []hostList {
+	return []hostList{
+		{name: "block list", resource: "blocklist", list: p.GetList, change: p.changeBlockList, importHosts: p.ImportBlockList},
+		{name: "allow list", resource: "allowlist", list: p.GetAllowList, change: p.changeAllowList, importHosts: p.ImportAllowList},
+	}
+}
+
+// apiHandler routes requests to the JSON admin API
+func (p *Procrastiproxy) apiHandler(w http.ResponseWriter, r *http.Request) {
+	p.GetLogger().WithFields(logrus.Fields{
+		"Method": r.Method,
+		"Path":   r.URL.Path,
+	}).Debug("Admin API received request")
+
+	resource := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(apiPrefix, "/"))
+
+	for _, hl := range p.apiHostLists() {
+		collection := "/" + hl.resource
+		switch {
+		case resource == collection || resource == collection+"/":
+			switch r.Method {
+			case http.MethodGet:
+				p.apiListHosts(w, r, hl)
+			case http.MethodPost:
+				p.apiAddHost(w, r, hl)
+			default:
+				writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+			}
+			return
+
+		// A host named import can still be removed, so only POST is routed to the import
+		case resource == collection+"/import" && r.Method == http.MethodPost:
+			p.apiImportHosts(w, r, hl)
+			return
+
+		case strings.HasPrefix(resource, collection+"/"):
+			if r.Method != http.MethodDelete {
+				writeMethodNotAllowed(w, http.MethodDelete)
+				return
+			}
+			p.apiRemoveHost(w, r, hl, strings.TrimPrefix(resource, collection+"/"))
+			return
+		}
+	}
+
+	switch {
+	case resource == "/schedule":
+		switch r.Method {
+		case http.MethodGet:
+			p.apiGetSchedule(w, r)
+		case http.MethodPut:
+			p.apiPutSchedule(w, r)
+		default:
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodPut)
+		}
+
+	case resource == "/status":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		p.apiStatus(w, r)
+
+	case resource == "/openapi.json":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(openAPIDocument))
+
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("No such resource: %s", r.URL.Path))
+	}
+}
+
+func (p *Procrastiproxy) apiListHosts(w http.ResponseWriter, r *http.Request, hl hostList) {
+	p.configMu.RLock()
+	hosts := hl.list().All()
+	p.configMu.RUnlock()
+
+	sort.Strings(hosts)
+	if hosts == nil {
+		hosts = []string{}
+	}
+	writeJSON(w, http.StatusOK, BlockListResponse{Hosts: hosts})
+}
+
+func (p *Procrastiproxy) apiAddHost(w http.ResponseWriter, r *http.Request, hl hostList) {
+	var entry BlockListEntry
+	if err := decodeJSONBody(w, r, &entry); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	host := listKey(entry.Host)
+	if err := validateAPIHost(host); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	changed, err := hl.change(host, true)
+	if err != nil {
+		p.writeAPIStoreError(w, host, err)
+		return
+	}
+	if !changed {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("%s is already on the %s", host, hl.name))
+		return
+	}
+	w.Header().Set("Location", apiPrefix+hl.resource+"/"+host)
+	writeJSON(w, http.StatusCreated, BlockListEntry{Host: host})
+}
+
+// apiImportHosts adds every host listed in the request body, which is a file in any format that ParseBlockFile
+// accepts, to the list. Nothing is imported if any line of the file is invalid
+func (p *Procrastiproxy) apiImportHosts(w http.ResponseWriter, r *http.Request, hl hostList) {
+	hosts, err := ParseBlockFile(http.MaxBytesReader(w, r.Body, maxImportBodyBytes))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: "Invalid " + hl.name + " file. Nothing was imported", Problems: configProblems(err)})
+		return
+	}
+
+	added, err := hl.importHosts(hosts)
+	if err != nil {
+		p.writeAPIStoreError(w, fmt.Sprintf("%d imported hosts", len(hosts)), err)
+		return
+	}
+	p.GetLogger().WithFields(logrus.Fields{
+		"List":     hl.name,
+		"Imported": len(hosts),
+		"Added":    added,
+	}).Info("Imported hosts via admin API")
+	writeJSON(w, http.StatusOK, ImportResponse{Imported: len(hosts), Added: added})
+}
+
+func (p *Procrastiproxy) apiRemoveHost(w http.ResponseWriter, r *http.Request, hl hostList, host string) {
+	host = listKey(host)
+	if err := validateAPIHost(host); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p.configMu.RLock()
+	present := hl.list().Contains(host)
+	p.configMu.RUnlock()
+	if !present {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%s is not on the %s", host, hl.name))
+		return
+	}
+
+	if _, err := hl.change(host, false); err != nil {
+		p.writeAPIStoreError(w, host, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Procrastiproxy) apiGetSchedule(w http.ResponseWriter, r *http.Request) {
+	p.configMu.RLock()
+	pts := p.GetProxyTimeSettings()
+	p.configMu.RUnlock()
+
+	writeJSON(w, http.StatusOK, newScheduleDocument(pts))
+}
+
+// apiPutSchedule replaces the global block times, windows, schedule and time zone. The new settings last until the
+// config file is next loaded
+func (p *Procrastiproxy) apiPutSchedule(w http.ResponseWriter, r *http.Request) {
+	var doc ScheduleDocument
+	if err := decodeJSONBody(w, r, &doc); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c := DefaultConfig()
+	c.merge(Config{
+		Timezone:       doc.Timezone,
+		BlockStartTime: doc.BlockStartTime,
+		BlockEndTime:   doc.BlockEndTime,
+		BlockWindows:   doc.BlockWindows,
+		Schedule:       doc.Schedule,
+	})
+	pts, err := c.parseTimeSettings()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: "Invalid schedule", Problems: configProblems(err)})
+		return
+	}
+
+	p.configMu.Lock()
+	p.timeSettings = pts
+	p.configMu.Unlock()
+
+	p.GetLogger().WithFields(logrus.Fields{
+		"Schedule": newScheduleDocument(pts),
+	}).Info("Schedule updated via admin API")
+	writeJSON(w, http.StatusOK, newScheduleDocument(pts))
+}
+
+func (p *Procrastiproxy) apiStatus(w http.ResponseWriter, r *http.Request) {
+	now := p.Now()
+
+	p.configMu.RLock()
+	window, inWindow := p.MatchBlockWindow(now)
+	blockedHosts := p.GetList().Length()
+	allowedHosts := p.GetAllowList().Length()
+	mode := p.GetMode()
+	location := p.getTimeSettings().location
+	p.configMu.RUnlock()
+
+	if location != nil {
+		now = now.In(location)
+	}
+	status := StatusResponse{
+		Time:          now.Format(time.RFC3339),
+		InBlockWindow: inWindow,
+		BlockedHosts:  blockedHosts,
+		AllowedHosts:  allowedHosts,
+		Mode:          mode,
+	}
+	if inWindow {
+		status.Window = window.String()
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// newScheduleDocument describes the time settings in the form the admin API accepts
+func newScheduleDocument(pts ProxyTimeSettings) ScheduleDocument {
+	doc := ScheduleDocument{
+		Timezone:       pts.Timezone,
+		BlockStartTime: pts.BlockStartTime,
+		BlockEndTime:   pts.BlockEndTime,
+	}
+	for _, bw := range pts.BlockWindows {
+		doc.BlockWindows = append(doc.BlockWindows, bw.String())
+	}
+	if pts.Schedule != nil {
+		doc.Schedule = pts.Schedule.entries()
+	}
+	return doc
+}
+
+// validateAPIHost rejects hosts that could never match a request
+func validateAPIHost(host string) error {
+	if host == "" {
+		return errors.New("A host is required. Example: {\"host\": \"reddit.com\"}")
+	}
+	// A / is only allowed as part of a network, such as 151.101.0.0/16
+	if _, _, ok := parseNetwork(host); ok {
+		return nil
+	}
+	if strings.ContainsAny(host, "/ \t?#") {
+		return fmt.Errorf("Invalid host {%s}. Supply a host such as reddit.com, *.reddit.com, localhost:8080 or 151.101.0.0/16, without a scheme or path", host)
+	}
+	return nil
+}
+
+// decodeJSONBody decodes the request body into v, rejecting unknown fields and trailing data
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("Invalid JSON request body: %v", err)
+	}
+	if decoder.More() {
+		return errors.New("Invalid JSON request body: unexpected data after the JSON object")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// Should the client have gone away, there is nobody left to report an encoding failure to
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, APIError{Error: message})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method not allowed. Allowed methods: %s", strings.Join(allowed, ", ")))
+}
+
+// writeAPIStoreError reports that a block or allow list change was applied, but could not be persisted
+func (p *Procrastiproxy) writeAPIStoreError(w http.ResponseWriter, host string, err error) {
+	p.GetLogger().WithFields(logrus.Fields{
+		"Host":  host,
+		"Error": err,
+	}).Error("Failed to persist list change")
+	writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("The change to %s was applied, but could not be saved, so it will be lost on restart: %v", host, err))
+}
@@ -0,0 +1,203 @@
+package procrastiproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveAPI sends a request with the supplied body, if any, to the admin API, returning the recorded response
+func serveAPI(t *testing.T, p *Procrastiproxy, method, path, body string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, "http://localhost:8000"+path, nil)
+	} else {
+		r = httptest.NewRequest(method, "http://localhost:8000"+path, strings.NewReader(body))
+	}
+	w := httptest.NewRecorder()
+	http.HandlerFunc(p.apiHandler).ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIBlockList(t *testing.T) {
+	p := NewProcrastiproxy()
+	p.GetList().Add("reddit.com")
+
+	w := serveAPI(t, p, http.MethodPost, "/api/v1/blocklist", `{"host": "Twitter.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "/api/v1/blocklist/twitter.com", w.Header().Get("Location"))
+	require.JSONEq(t, `{"host": "twitter.com"}`, w.Body.String())
+
+	w = serveAPI(t, p, http.MethodGet, "/api/v1/blocklist", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"hosts": ["reddit.com", "twitter.com"]}`, w.Body.String())
+
+	w = serveAPI(t, p, http.MethodDelete, "/api/v1/blocklist/reddit.com", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.False(t, p.GetList().Contains("reddit.com"))
+}
+
+func TestAPIBlockListNetworks(t *testing.T) {
+	p := NewProcrastiproxy()
+
+	w := serveAPI(t, p, http.MethodPost, "/api/v1/blocklist", `{"host": "151.101.7.7/16"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "/api/v1/blocklist/151.101.0.0/16", w.Header().Get("Location"))
+	require.JSONEq(t, `{"host": "151.101.0.0/16"}`, w.Body.String())
+
+	w = serveAPI(t, p, http.MethodPost, "/api/v1/blocklist", `{"host": "151.101.0.0/33"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveAPI(t, p, http.MethodDelete, "/api/v1/blocklist/151.101.0.0/16", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, 0, p.GetList().Length())
+}
+
+func TestAPIImport(t *testing.T) {
+	p := NewProcrastiproxy()
+	p.GetList().Add("reddit.com")
+
+	file := "# Distractions\n0.0.0.0 reddit.com www.reddit.com\n||youtube.com^\nnews.ycombinator.com\nYouTube.com\n"
+	w := serveAPI(t, p, http.MethodPost, "/api/v1/blocklist/import", file)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"imported": 4, "added": 3}`, w.Body.String())
+
+	w = serveAPI(t, p, http.MethodGet, "/api/v1/blocklist", "")
+	require.JSONEq(t, `{"hosts": ["news.ycombinator.com", "reddit.com", "www.reddit.com", "youtube.com"]}`, w.Body.String())
+
+	// Nothing is imported from a file with invalid lines, each of which is reported
+	w = serveAPI(t, p, http.MethodPost, "/api/v1/allowlist/import", "docs.github.com\n||github.com/about^\n@@||example.com^\n")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var apiErr APIError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &apiErr))
+	require.Len(t, apiErr.Problems, 2)
+	require.Contains(t, apiErr.Problems[0], "line 2")
+	require.Contains(t, apiErr.Problems[1], "line 3")
+	require.Equal(t, 0, p.GetAllowList().Length())
+
+	// A host named import can still be removed
+	p.GetList().Add("import")
+	w = serveAPI(t, p, http.MethodDelete, "/api/v1/blocklist/import", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestAPIErrors(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Method     string
+		Path       string
+		Body       string
+		WantStatus int
+		WantAllow  string
+	}{
+		{Name: "Already blocked", Method: http.MethodPost, Path: "/api/v1/blocklist", Body: `{"host": "reddit.com"}`, WantStatus: http.StatusConflict},
+		{Name: "Malformed JSON", Method: http.MethodPost, Path: "/api/v1/blocklist", Body: `{"host": `, WantStatus: http.StatusBadRequest},
+		{Name: "Unknown field", Method: http.MethodPost, Path: "/api/v1/blocklist", Body: `{"hostname": "reddit.com"}`, WantStatus: http.StatusBadRequest},
+		{Name: "Missing host", Method: http.MethodPost, Path: "/api/v1/blocklist", Body: `{}`, WantStatus: http.StatusBadRequest},
+		{Name: "Host with path", Method: http.MethodPost, Path: "/api/v1/blocklist", Body: `{"host": "reddit.com/r/golang"}`, WantStatus: http.StatusBadRequest},
+		{Name: "Unblock unknown host", Method: http.MethodDelete, Path: "/api/v1/blocklist/twitter.com", WantStatus: http.StatusNotFound},
+		{Name: "Unknown resource", Method: http.MethodGet, Path: "/api/v1/blocklists", WantStatus: http.StatusNotFound},
+		{Name: "Wrong method on block list", Method: http.MethodPut, Path: "/api/v1/blocklist", WantStatus: http.StatusMethodNotAllowed, WantAllow: "GET, POST"},
+		{Name: "Wrong method on host", Method: http.MethodGet, Path: "/api/v1/blocklist/reddit.com", WantStatus: http.StatusMethodNotAllowed, WantAllow: "DELETE"},
+		{Name: "Wrong method on schedule", Method: http.MethodPost, Path: "/api/v1/schedule", WantStatus: http.StatusMethodNotAllowed, WantAllow: "GET, PUT"},
+		{Name: "Wrong method on status", Method: http.MethodDelete, Path: "/api/v1/status", WantStatus: http.StatusMethodNotAllowed, WantAllow: "GET"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			p.GetList().Add("reddit.com")
+
+			w := serveAPI(t, p, tc.Method, tc.Path, tc.Body)
+			require.Equal(t, tc.WantStatus, w.Code)
+			require.Equal(t, tc.WantAllow, w.Header().Get("Allow"))
+
+			var apiErr APIError
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &apiErr))
+			require.NotEmpty(t, apiErr.Error)
+		})
+	}
+}
+
+func TestAPISchedule(t *testing.T) {
+	p := NewProcrastiproxy()
+
+	w := serveAPI(t, p, http.MethodGet, "/api/v1/schedule", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"timezone": "", "block_start_time": "9:00AM", "block_end_time": "5:00PM"}`, w.Body.String())
+
+	w = serveAPI(t, p, http.MethodPut, "/api/v1/schedule", `{"timezone": "America/New_York", "schedule": ["mon-fri=9:00AM-12:00PM,1:00PM-5:00PM", "sat=10:00AM-12:00PM"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc ScheduleDocument
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.Equal(t, "America/New_York", doc.Timezone)
+	require.Equal(t, []string{
+		"mon=9:00AM-12:00PM,1:00PM-5:00PM",
+		"tue=9:00AM-12:00PM,1:00PM-5:00PM",
+		"wed=9:00AM-12:00PM,1:00PM-5:00PM",
+		"thu=9:00AM-12:00PM,1:00PM-5:00PM",
+		"fri=9:00AM-12:00PM,1:00PM-5:00PM",
+		"sat=10:00AM-12:00PM",
+		"sun=off",
+	}, doc.Schedule)
+
+	// 10:00AM on a Monday in New York is blocked, and 12:30PM isn't
+	require.True(t, p.WithinBlockWindow(time.Date(2022, time.June, 6, 14, 0, 0, 0, time.UTC)))
+	require.False(t, p.WithinBlockWindow(time.Date(2022, time.June, 6, 16, 30, 0, 0, time.UTC)))
+
+	// Every problem is reported, and the schedule is left as it was
+	w = serveAPI(t, p, http.MethodPut, "/api/v1/schedule", `{"timezone": "Mars/Olympus_Mons", "schedule": ["someday=9:00AM-5:00PM"]}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var apiErr APIError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &apiErr))
+	require.Len(t, apiErr.Problems, 2)
+	require.Equal(t, "America/New_York", p.GetProxyTimeSettings().Timezone)
+}
+
+func TestAPIStatus(t *testing.T) {
+	p := NewProcrastiproxy()
+	p.GetList().Add("reddit.com")
+	WithClock(NewFakeClock(time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC)))(p)
+
+	w := serveAPI(t, p, http.MethodGet, "/api/v1/status", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"time": "2022-06-06T10:00:00Z", "in_block_window": true, "window": "9:00AM-5:00PM", "blocked_hosts": 1, "allowed_hosts": 0, "mode": "deny"}`, w.Body.String())
+}
+
+func TestAPIServesOpenAPIDocument(t *testing.T) {
+	w := serveAPI(t, NewProcrastiproxy(), http.MethodGet, "/api/v1/openapi.json", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.Contains(t, doc.Paths["/blocklist"], "get")
+	require.Contains(t, doc.Paths["/blocklist"], "post")
+	require.Contains(t, doc.Paths["/blocklist/{host}"], "delete")
+	require.Contains(t, doc.Paths["/allowlist"], "get")
+	require.Contains(t, doc.Paths["/allowlist"], "post")
+	require.Contains(t, doc.Paths["/allowlist/{host}"], "delete")
+	require.Contains(t, doc.Paths["/blocklist/import"], "post")
+	require.Contains(t, doc.Paths["/allowlist/import"], "post")
+	require.Contains(t, doc.Paths["/schedule"], "get")
+	require.Contains(t, doc.Paths["/schedule"], "put")
+	require.Contains(t, doc.Paths["/status"], "get")
+}
+
+func TestAdminHandlerRejectsMalformedPaths(t *testing.T) {
+	for _, path := range []string{"/admin/", "/admin/frobnicate/reddit.com", "/admin/block/"} {
+		p := NewProcrastiproxy()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8000"+path, nil)
+		http.HandlerFunc(p.adminHandler).ServeHTTP(w, r)
+		require.Equal(t, http.StatusBadRequest, w.Code, path)
+		require.Equal(t, 0, p.GetList().Length())
+	}
+}
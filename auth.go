@@ -0,0 +1,179 @@
+package procrastiproxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// adminTokenEnv and adminPasswordEnv name the environment variables that admin credentials can be supplied in,
+	// which keeps them out of config files and the process list
+	adminTokenEnv    = "PROCRASTIPROXY_ADMIN_TOKEN"
+	adminPasswordEnv = "PROCRASTIPROXY_ADMIN_PASSWORD"
+
+	// unixSocketPrefix marks an admin address as the path of a Unix socket, e.g., unix:/run/procrastiproxy/admin.sock
+	unixSocketPrefix = "unix:"
+
+	adminRealm = "procrastiproxy admin"
+
+	defaultAdminUsername = "admin"
+)
+
+// AdminAuth holds the credentials that requests to the admin endpoints must present. A request is allowed if it
+// carries either the bearer token or the basic auth username and password. When neither is set, the admin endpoints
+// are open to anyone who can reach them
+type AdminAuth struct {
+	Token    string
+	Username string
+	Password string
+}
+
+// Enabled reports whether any credentials are configured
+func (a AdminAuth) Enabled() bool {
+	return a.Token != "" || a.Password != ""
+}
+
+// authenticate checks the credentials presented by r, returning why they were rejected if they were
+func (a AdminAuth) authenticate(r *http.Request) (bool, string) {
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return false, "missing credentials"
+	}
+
+	if a.Token != "" {
+		if token, ok := bearerToken(authorization); ok {
+			if secretsEqual(token, a.Token) {
+				return true, ""
+			}
+			return false, "invalid bearer token"
+		}
+	}
+
+	if a.Password != "" {
+		if username, password, ok := r.BasicAuth(); ok {
+			// Check both, so that the time taken doesn't reveal which one was wrong
+			usernameOK := secretsEqual(username, a.Username)
+			passwordOK := secretsEqual(password, a.Password)
+			if usernameOK && passwordOK {
+				return true, ""
+			}
+			return false, "invalid basic auth credentials"
+		}
+	}
+
+	return false, "unsupported authorization scheme"
+}
+
+// bearerToken extracts the token from an Authorization header of the form "Bearer <token>"
+func bearerToken(authorization string) (string, bool) {
+	const prefix = "bearer "
+	if len(authorization) < len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
+
+// secretsEqual compares two secrets in constant time. Comparing their digests, rather than the secrets themselves,
+// also avoids revealing the length of the expected secret
+func secretsEqual(given, want string) bool {
+	givenSum := sha256.Sum256([]byte(given))
+	wantSum := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(givenSum[:], wantSum[:]) == 1
+}
+
+// challenge returns the WWW-Authenticate header values for the configured schemes
+func (a AdminAuth) challenge() []string {
+	var challenges []string
+	if a.Token != "" {
+		challenges = append(challenges, fmt.Sprintf("Bearer realm=%q", adminRealm))
+	}
+	if a.Password != "" {
+		challenges = append(challenges, fmt.Sprintf("Basic realm=%q", adminRealm))
+	}
+	return challenges
+}
+
+// requireAdminAuth wraps an admin handler so that it is only reached by requests presenting the configured admin
+// credentials. Failed attempts are logged for auditing
+func (p *Procrastiproxy) requireAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.configMu.RLock()
+		auth := p.AdminAuth
+		p.configMu.RUnlock()
+
+		if !auth.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, reason := auth.authenticate(r)
+		if !ok {
+			p.GetLogger().WithFields(logrus.Fields{
+				"Remote Address": r.RemoteAddr,
+				"Method":         r.Method,
+				"Path":           r.URL.Path,
+				"User Agent":     r.UserAgent(),
+				"Reason":         reason,
+			}).Warn("Rejected unauthenticated admin request")
+
+			for _, challenge := range auth.challenge() {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+			if strings.HasPrefix(r.URL.Path, apiPrefix) {
+				writeAPIError(w, http.StatusUnauthorized, "Valid admin credentials are required")
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized\n"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenAdmin opens the listener for a separate admin address, which is either a TCP address such as 127.0.0.1:8001,
+// or the path of a Unix socket prefixed with unix:
+func listenAdmin(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixSocketPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, unixSocketPrefix)
+	// Remove a socket left behind by a previous run that didn't shut down cleanly
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Only the user running procrastiproxy may connect
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// adminAddressIsLocal reports whether the admin address can only be reached from this machine
+func adminAddressIsLocal(address string) bool {
+	if strings.HasPrefix(address, unixSocketPrefix) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
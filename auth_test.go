@@ -0,0 +1,196 @@
+package procrastiproxy
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireAdminAuth(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Auth       AdminAuth
+		SetHeaders func(r *http.Request)
+		WantStatus int
+		WantReason string
+	}{
+		{
+			Name:       "No credentials configured",
+			Auth:       AdminAuth{},
+			SetHeaders: func(r *http.Request) {},
+			WantStatus: http.StatusOK,
+		},
+		{
+			Name:       "Valid bearer token",
+			Auth:       AdminAuth{Token: "s3cret"},
+			SetHeaders: func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") },
+			WantStatus: http.StatusOK,
+		},
+		{
+			Name:       "Invalid bearer token",
+			Auth:       AdminAuth{Token: "s3cret"},
+			SetHeaders: func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") },
+			WantStatus: http.StatusUnauthorized,
+			WantReason: "invalid bearer token",
+		},
+		{
+			Name:       "Missing credentials",
+			Auth:       AdminAuth{Token: "s3cret"},
+			SetHeaders: func(r *http.Request) {},
+			WantStatus: http.StatusUnauthorized,
+			WantReason: "missing credentials",
+		},
+		{
+			Name:       "Valid basic auth",
+			Auth:       AdminAuth{Username: "admin", Password: "hunter2"},
+			SetHeaders: func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") },
+			WantStatus: http.StatusOK,
+		},
+		{
+			Name:       "Invalid basic auth",
+			Auth:       AdminAuth{Username: "admin", Password: "hunter2"},
+			SetHeaders: func(r *http.Request) { r.SetBasicAuth("admin", "hunter3") },
+			WantStatus: http.StatusUnauthorized,
+			WantReason: "invalid basic auth credentials",
+		},
+		{
+			Name:       "Basic auth when only a token is configured",
+			Auth:       AdminAuth{Token: "s3cret"},
+			SetHeaders: func(r *http.Request) { r.SetBasicAuth("admin", "s3cret") },
+			WantStatus: http.StatusUnauthorized,
+			WantReason: "unsupported authorization scheme",
+		},
+		{
+			Name:       "Either scheme when both are configured",
+			Auth:       AdminAuth{Token: "s3cret", Username: "admin", Password: "hunter2"},
+			SetHeaders: func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") },
+			WantStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+
+			p := NewProcrastiproxy()
+			p.AdminAuth = tc.Auth
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://localhost:8000/admin/block/reddit.com", nil)
+			tc.SetHeaders(r)
+			p.requireAdminAuth(http.HandlerFunc(p.adminHandler)).ServeHTTP(w, r)
+
+			require.Equal(t, tc.WantStatus, w.Code)
+			if tc.WantStatus == http.StatusOK {
+				require.True(t, p.GetList().Contains("reddit.com"))
+				return
+			}
+
+			require.False(t, p.GetList().Contains("reddit.com"))
+			require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+
+			// Failed attempts are logged for auditing
+			entry := hook.LastEntry()
+			require.NotNil(t, entry)
+			require.Equal(t, log.WarnLevel, entry.Level)
+			require.Equal(t, tc.WantReason, entry.Data["Reason"])
+			require.Equal(t, "/admin/block/reddit.com", entry.Data["Path"])
+		})
+	}
+}
+
+func TestRequireAdminAuthReturnsJSONForAPI(t *testing.T) {
+	p := NewProcrastiproxy()
+	p.AdminAuth = AdminAuth{Token: "s3cret"}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://localhost:8000/api/v1/blocklist", nil)
+	p.requireAdminAuth(http.HandlerFunc(p.apiHandler)).ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, `Bearer realm="procrastiproxy admin"`, w.Header().Get("WWW-Authenticate"))
+	require.JSONEq(t, `{"error": "Valid admin credentials are required"}`, w.Body.String())
+}
+
+func TestAdminCredentialsFromEnvironment(t *testing.T) {
+	t.Setenv(adminTokenEnv, "from-env")
+	t.Setenv(adminPasswordEnv, "hunter2")
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, "block:\n  - reddit.com\nadmin_token: from-file\n")
+
+	fs := flag.NewFlagSet("procrastiproxy", flag.ContinueOnError)
+	flags := registerFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--config", path}))
+
+	c, _, err := flags.resolveConfig(fs)
+	require.NoError(t, err)
+
+	p := NewProcrastiproxy()
+	require.NoError(t, p.ApplyConfig(c))
+	require.Equal(t, AdminAuth{Token: "from-env", Username: defaultAdminUsername, Password: "hunter2"}, p.AdminAuth)
+}
+
+func TestApplyConfigValidatesAdminSettings(t *testing.T) {
+	c := DefaultConfig()
+	c.Block = []string{"reddit.com"}
+	c.AdminAddress = "localhost"
+	c.AdminUsername = "admin"
+
+	err := NewProcrastiproxy().ApplyConfig(c)
+	require.Error(t, err)
+	require.Len(t, configProblems(err), 2)
+}
+
+func TestListenAdminUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	listener, err := listenAdmin(unixSocketPrefix + path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://procrastiproxy/admin/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "admin", string(body))
+}
+
+func TestAdminAddressIsLocal(t *testing.T) {
+	testCases := []struct {
+		Address   string
+		WantLocal bool
+	}{
+		{Address: "unix:/run/procrastiproxy/admin.sock", WantLocal: true},
+		{Address: "127.0.0.1:8001", WantLocal: true},
+		{Address: "[::1]:8001", WantLocal: true},
+		{Address: "localhost:8001", WantLocal: true},
+		{Address: ":8001", WantLocal: false},
+		{Address: "0.0.0.0:8001", WantLocal: false},
+		{Address: "192.168.1.10:8001", WantLocal: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Address, func(t *testing.T) {
+			require.Equal(t, tc.WantLocal, adminAddressIsLocal(tc.Address))
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package procrastiproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// maxBlockFileLineBytes caps the length of a single line of a block list file
+const maxBlockFileLineBytes = 64 * 1024
+
+// hostsFileNames are the names that hosts files map to local addresses for the system's own use, rather than to block
+// them, e.g., 127.0.0.1 localhost
+var hostsFileNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+type BlockFileError struct {
+	Path       string
+	Underlying error
+}
+
+func (err BlockFileError) Error() string {
+	return fmt.Sprintf("Unable to read block file {%s}: %v", err.Path, err.Underlying)
+}
+
+func (err BlockFileError) Unwrap() error {
+	return err.Underlying
+}
+
+// BlockFileLineError reports a line of a block list file that could not be parsed
+type BlockFileLineError struct {
+	// Path is the file the line was read from. It is empty for lists that weren't read from a file, such as those
+	// imported through the admin API
+	Path    string
+	Line    int
+	Content string
+	Reason  string
+}
+
+func (err BlockFileLineError) Error() string {
+	if err.Path == "" {
+		return fmt.Sprintf("Invalid entry {%s} on line %d: %s", err.Content, err.Line, err.Reason)
+	}
+	return fmt.Sprintf("Invalid entry {%s} on line %d of block file {%s}: %s", err.Content, err.Line, err.Path, err.Reason)
+}
+
+// LoadBlockFile reads the hosts listed in the block list file at path. See ParseBlockFile for the formats it accepts
+func LoadBlockFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, BlockFileError{Path: path, Underlying: err}
+	}
+	defer f.Close()
+	return parseBlockFile(path, f)
+}
+
+// ParseBlockFile reads a block list in any of these formats, which may be mixed within one list:
+//
+//	reddit.com                one host per line, in any form the block list accepts
+//	0.0.0.0 reddit.com        hosts files, such as /etc/hosts, where every name after the address is a host
+//	||reddit.com^             Adblock domain rules
+//
+// Blank lines and comments, which start with # or, in Adblock lists, !, are skipped. The hosts are returned in the
+// order they were listed, without duplicates. Every line that can't be parsed is reported, with its line number,
+// together in the returned error
+func ParseBlockFile(r io.Reader) ([]string, error) {
+	return parseBlockFile("", r)
+}
+
+func parseBlockFile(path string, r io.Reader) ([]string, error) {
+	var result *multierror.Error
+	var hosts []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxBlockFileLineBytes)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if line == 1 {
+			// Files saved by some Windows editors start with a byte order mark
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		lineHosts, err := parseBlockFileLine(text)
+		if err != nil {
+			result = multierror.Append(result, BlockFileLineError{Path: path, Line: line, Content: strings.TrimSpace(text), Reason: err.Error()})
+			continue
+		}
+		for _, host := range lineHosts {
+			host = listKey(host)
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if path != "" {
+			err = BlockFileError{Path: path, Underlying: err}
+		}
+		result = multierror.Append(result, err)
+	}
+	return hosts, result.ErrorOrNil()
+}
+
+// parseBlockFileLine returns the hosts listed on a single line of a block list file
+func parseBlockFileLine(text string) ([]string, error) {
+	line := strings.TrimSpace(text)
+	switch {
+	case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, "!"):
+		return nil, nil
+	case strings.HasPrefix(strings.ToLower(line), "[adblock"):
+		// Adblock lists start with a header naming the syntax version, e.g., [Adblock Plus 2.0]
+		return nil, nil
+	case strings.HasPrefix(line, "||"):
+		host, err := parseAdblockRule(line)
+		if err != nil {
+			return nil, err
+		}
+		return []string{host}, nil
+	case strings.HasPrefix(line, "@@"):
+		return nil, errors.New("Adblock exception rules are not supported. Add the host to the allow list instead")
+	case strings.Contains(line, "##") || strings.Contains(line, "#@#"):
+		return nil, errors.New("Adblock element hiding rules are not supported")
+	}
+
+	// Hosts files may end a line with a comment, e.g., 0.0.0.0 reddit.com # social
+	if i := strings.Index(line, "#"); i > 0 && unicode.IsSpace(rune(line[i-1])) {
+		line = strings.TrimSpace(line[:i])
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		if net.ParseIP(fields[0]) == nil {
+			return nil, errors.New("expected a single host, or an address followed by hosts, as in 0.0.0.0 reddit.com")
+		}
+		var hosts []string
+		for _, host := range fields[1:] {
+			if hostsFileNames[strings.ToLower(host)] || net.ParseIP(host) != nil {
+				continue
+			}
+			if err := validateBlockFileHost(host); err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, host)
+		}
+		return hosts, nil
+	}
+
+	if err := validateBlockFileHost(fields[0]); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// parseAdblockRule returns the domain blocked by an Adblock domain rule, such as ||reddit.com^, which blocks the
+// domain and its subdomains, just as a block list entry does. Rules that block only some URLs on a domain are rejected
+func parseAdblockRule(rule string) (string, error) {
+	domain := strings.TrimPrefix(rule, "||")
+	if strings.Contains(domain, "$") {
+		return "", errors.New("Adblock rule options, such as $third-party, are not supported")
+	}
+	domain = strings.TrimSuffix(strings.TrimSuffix(domain, "|"), "^")
+	if domain == "" || strings.ContainsAny(domain, "/^*|") {
+		return "", errors.New("only Adblock domain rules, such as ||reddit.com^, are supported")
+	}
+	return domain, nil
+}
+
+// validateBlockFileHost checks that host is something the block list can hold: a domain, wildcard domain, host and
+// port, or IP address or network
+func validateBlockFileHost(host string) error {
+	if strings.Contains(host, "/") {
+		if _, _, err := net.ParseCIDR(host); err != nil {
+			return fmt.Errorf("invalid network, which must be in CIDR notation, e.g., 151.101.0.0/16: %v", err)
+		}
+		return nil
+	}
+	if strings.ContainsAny(host, "?#=$^|@,\"'") {
+		return errors.New("not a host. Supply a host such as reddit.com, *.reddit.com, localhost:8080 or 151.101.0.0/16")
+	}
+	return nil
+}
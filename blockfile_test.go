@@ -0,0 +1,101 @@
+package procrastiproxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlockFile(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		File      string
+		WantHosts []string
+	}{
+		{
+			Name:      "One host per line",
+			File:      "reddit.com\n*.youtube.com\n\nlocalhost:8080\n151.101.0.0/16\n",
+			WantHosts: []string{"reddit.com", "*.youtube.com", "localhost:8080", "151.101.0.0/16"},
+		},
+		{
+			Name: "Hosts file",
+			File: "# Block distractions\n127.0.0.1 localhost\n::1 localhost ip6-localhost ip6-loopback\n" +
+				"0.0.0.0 0.0.0.0\n0.0.0.0 reddit.com www.reddit.com # social\n127.0.0.1\tnews.ycombinator.com\n",
+			WantHosts: []string{"reddit.com", "www.reddit.com", "news.ycombinator.com"},
+		},
+		{
+			Name:      "Adblock domain rules",
+			File:      "[Adblock Plus 2.0]\n! Title: Distractions\n||reddit.com^\n||youtube.com^|\n||news.ycombinator.com\n",
+			WantHosts: []string{"reddit.com", "youtube.com", "news.ycombinator.com"},
+		},
+		{
+			Name:      "Mixed formats are deduplicated",
+			File:      "reddit.com\n0.0.0.0 Reddit.com\n||reddit.com.^\nyoutube.com\n",
+			WantHosts: []string{"reddit.com", "youtube.com"},
+		},
+		{
+			Name:      "Byte order mark and Windows line endings",
+			File:      "\ufeffreddit.com\r\nyoutube.com\r\n",
+			WantHosts: []string{"reddit.com", "youtube.com"},
+		},
+		{
+			Name: "Empty file",
+			File: "# Nothing yet\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			hosts, err := ParseBlockFile(strings.NewReader(tc.File))
+			require.NoError(t, err)
+			require.Equal(t, tc.WantHosts, hosts)
+		})
+	}
+}
+
+func TestParseBlockFileReportsEveryInvalidLine(t *testing.T) {
+	file := strings.Join([]string{
+		"reddit.com",
+		"||youtube.com^$third-party",
+		"@@||docs.youtube.com^",
+		"example.com##.advert",
+		"||example.com/ads^",
+		"reddit.com twitter.com",
+		"0.0.0.0 twitter.com/home",
+		"151.101.0.0/33",
+		"youtube.com",
+	}, "\n")
+
+	hosts, err := ParseBlockFile(strings.NewReader(file))
+	require.Equal(t, []string{"reddit.com", "youtube.com"}, hosts)
+
+	var merr *multierror.Error
+	require.True(t, errors.As(err, &merr))
+	var lines []int
+	for _, e := range merr.Errors {
+		var lineErr BlockFileLineError
+		require.True(t, errors.As(e, &lineErr))
+		lines = append(lines, lineErr.Line)
+	}
+	require.Equal(t, []int{2, 3, 4, 5, 6, 7, 8}, lines)
+	require.Contains(t, err.Error(), "Invalid entry {||youtube.com^$third-party} on line 2")
+}
+
+func TestLoadBlockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, ioutil.WriteFile(path, []byte("0.0.0.0 reddit.com\n||youtube.com/shorts^\n"), 0644))
+
+	hosts, err := LoadBlockFile(path)
+	require.Equal(t, []string{"reddit.com"}, hosts)
+	var lineErr BlockFileLineError
+	require.True(t, errors.As(err, &lineErr))
+	require.Equal(t, path, lineErr.Path)
+	require.Equal(t, 2, lineErr.Line)
+
+	_, err = LoadBlockFile(filepath.Join(t.TempDir(), "missing"))
+	require.True(t, errors.As(err, &BlockFileError{}))
+}
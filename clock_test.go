@@ -0,0 +1,123 @@
+package procrastiproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2022, time.June, 6, 8, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	require.Equal(t, start, clock.Now())
+
+	clock.Advance(90 * time.Minute)
+	require.Equal(t, start.Add(90*time.Minute), clock.Now())
+
+	clock.Set(start)
+	require.Equal(t, start, clock.Now())
+}
+
+func TestWithClockDrivesBlockWindow(t *testing.T) {
+	testHost, testURL := newTestUpstream(t)
+	clock := NewFakeClock(time.Date(2022, time.June, 6, 8, 59, 0, 0, time.UTC))
+	p := NewProcrastiproxy(WithClock(clock))
+	p.GetList().Add(testHost)
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		p.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, testURL, nil))
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, get())
+	clock.Advance(time.Minute)
+	require.Equal(t, http.StatusForbidden, get())
+	clock.Advance(8 * time.Hour)
+	require.Equal(t, http.StatusOK, get())
+}
+
+func TestConfigureRejectsInvalidTimes(t *testing.T) {
+	p := NewProcrastiproxy()
+	require.NoError(t, p.ConfigureBlockWindows([]BlockWindow{{Start: "1:00PM", End: "2:00PM"}}))
+
+	require.Error(t, p.ConfigureProxyTimeSettings("9:00", "5:00PM"))
+	require.Error(t, p.ConfigureBlockWindows([]BlockWindow{{Start: "9:00AM", End: "noon"}}))
+	require.Error(t, p.ConfigureSchedule(WeeklySchedule{time.Monday: {{Start: "25:00PM", End: "5:00PM"}}}))
+
+	// The settings in place before the invalid ones were supplied still apply
+	window, ok := p.MatchBlockWindow(time.Date(2022, time.June, 6, 13, 30, 0, 0, time.UTC))
+	require.True(t, ok)
+	require.Equal(t, BlockWindow{Start: "1:00PM", End: "2:00PM"}, window)
+	require.Equal(t, "9:00AM", p.GetProxyTimeSettings().BlockStartTime)
+}
+
+func TestAddWithScheduleRejectsInvalidTimes(t *testing.T) {
+	l := NewList()
+	err := l.AddWithSchedule("youtube.com", &HostSchedule{BlockWindows: []BlockWindow{{Start: "9:00AM", End: "later"}}})
+	require.Error(t, err)
+	require.IsType(t, InvalidHostScheduleError{}, err)
+	require.False(t, l.Contains("youtube.com"))
+}
+
+func TestInvalidOptionsAreLoggedAndIgnored(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	p := NewProcrastiproxy(WithLogger(logger), WithBlockTimes("6:00PM", "whenever"))
+
+	require.Equal(t, "9:00AM", p.GetProxyTimeSettings().BlockStartTime)
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, logrus.WarnLevel, entry.Level)
+	require.Equal(t, "block times", entry.Data["Option"])
+}
+
+// TestUnconfiguredTimeSettings ensures that a proxy whose time settings were never configured blocks during the
+// default window
+func TestUnconfiguredTimeSettings(t *testing.T) {
+	p := &Procrastiproxy{List: NewList(), AllowList: NewList()}
+	require.True(t, p.WithinBlockWindow(time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC)))
+	require.False(t, p.WithinBlockWindow(time.Date(2022, time.June, 6, 22, 30, 0, 0, time.UTC)))
+	require.Equal(t, "9:00AM", p.GetProxyTimeSettings().BlockStartTime)
+	require.Equal(t, "5:00PM", p.GetProxyTimeSettings().BlockEndTime)
+}
+
+// TestGetProxyTimeSettingsReturnsCopy ensures that the settings can only be changed through the Configure methods
+func TestGetProxyTimeSettingsReturnsCopy(t *testing.T) {
+	evening := time.Date(2022, time.June, 6, 22, 30, 0, 0, time.UTC)
+	p := NewProcrastiproxy(WithBlockWindows([]BlockWindow{{Start: "9:00AM", End: "5:00PM"}}))
+
+	pts := p.GetProxyTimeSettings()
+	pts.BlockWindows[0] = BlockWindow{Start: "10:00PM", End: "11:00PM"}
+	require.False(t, p.WithinBlockWindow(evening))
+	require.Equal(t, []BlockWindow{{Start: "9:00AM", End: "5:00PM"}}, p.GetProxyTimeSettings().BlockWindows)
+
+	require.NoError(t, p.ConfigureBlockWindows(pts.BlockWindows))
+	require.True(t, p.WithinBlockWindow(evening))
+}
+
+// TestConfigureWhileServing ensures that the time settings can be changed while requests are being decided. It is
+// meant to be run with the race detector
+func TestConfigureWhileServing(t *testing.T) {
+	p := NewProcrastiproxy(WithClock(NewFakeClock(time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC))))
+	p.GetList().Add("reddit.com")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, p.ConfigureProxyTimeSettings("9:00AM", "5:00PM"))
+			require.NoError(t, p.ConfigureBlockWindows([]BlockWindow{{Start: "9:00AM", End: "12:00PM"}}))
+			require.NoError(t, p.ConfigureTimezone("UTC"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://reddit.com/", nil)
+		require.True(t, p.getPolicy().Decide(r.Context(), r, p.Now()).Blocked)
+	}
+	<-done
+}
@@ -0,0 +1,522 @@
+package procrastiproxy
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFileName is the config file procrastiproxy looks for in the working directory
+const defaultConfigFileName = ".procrastiproxy.yaml"
+
+// Config holds every setting procrastiproxy can be configured with. Values are read from a YAML config file, and then
+// overridden by any flags that were explicitly passed. Times, windows and schedules use the same formats as their flags
+type Config struct {
+	Port     string   `yaml:"port"`
+	LogLevel string   `yaml:"loglevel"`
+	Block    []string `yaml:"block"`
+	// BlockFiles are files whose hosts are added to the block list. They may list one host per line, or be in hosts
+	// file or Adblock domain rule format
+	BlockFiles []string `yaml:"block_files"`
+	// Allow lists the hosts that are permitted during a block window, even when the block list covers them
+	Allow []string `yaml:"allow"`
+	// Mode is either deny, the default, which refuses hosts on the block list, or allow, which refuses every host
+	// that isn't on the allow list
+	Mode string `yaml:"mode"`
+	// URLRules block or permit requests by their URL, e.g., ["youtube.com/shorts*", "allow youtube.com/watch*"]
+	URLRules []string `yaml:"url_rules"`
+	// ResolveHosts also refuses hostnames that resolve into a network on the block list, such as 151.101.0.0/16
+	ResolveHosts   bool     `yaml:"resolve_hosts"`
+	BlockStartTime string   `yaml:"block_start_time"`
+	BlockEndTime   string   `yaml:"block_end_time"`
+	BlockWindows   []string `yaml:"block_windows"`
+	// Schedule entries are applied in order, e.g., ["mon-fri=9:00AM-5:00PM", "sat=10:00AM-12:00PM", "sun=off"]
+	Schedule      []string `yaml:"schedule"`
+	HostSchedules []string `yaml:"host_schedules"`
+	Timezone      string   `yaml:"timezone"`
+	// StateFile is where changes made through the admin endpoints are saved. Defaults to
+	// $XDG_STATE_HOME/procrastiproxy/state.json
+	StateFile string `yaml:"state_file"`
+	// ProxyAddress, when set, is the address the proxy listens on, overriding Port, e.g., 127.0.0.1:8000
+	ProxyAddress string `yaml:"proxy_address"`
+	// AdminAddress, when set, is where the admin endpoints are served, rather than on the proxy's listener. It is either
+	// a TCP address, such as 127.0.0.1:8001, or a Unix socket, such as unix:/run/procrastiproxy/admin.sock
+	AdminAddress string `yaml:"admin_address"`
+	// AdminToken and AdminPassword may also be supplied via the PROCRASTIPROXY_ADMIN_TOKEN and
+	// PROCRASTIPROXY_ADMIN_PASSWORD environment variables, which take precedence over the config file
+	AdminToken    string `yaml:"admin_token"`
+	AdminUsername string `yaml:"admin_username"`
+	AdminPassword string `yaml:"admin_password"`
+}
+
+// DefaultConfig returns the settings procrastiproxy uses when neither a config file nor flags say otherwise
+func DefaultConfig() Config {
+	return Config{
+		Port:           "8000",
+		LogLevel:       "info",
+		Mode:           string(ModeDeny),
+		BlockStartTime: defaultBlockStartTime,
+		BlockEndTime:   defaultBlockEndTime,
+		StateFile:      defaultStatePath(),
+	}
+}
+
+type InvalidConfigError struct {
+	Path       string
+	Underlying error
+}
+
+func (err InvalidConfigError) Error() string {
+	source := err.Path
+	if source == "" {
+		source = "flags"
+	}
+	return fmt.Sprintf("Invalid procrastiproxy configuration from {%s}. %v", source, err.Underlying)
+}
+
+func (err InvalidConfigError) Unwrap() error {
+	return err.Underlying
+}
+
+// configSearchPaths returns, in order of preference, the locations procrastiproxy looks for a config file in when
+// one isn't passed via --config: the working directory, and then $XDG_CONFIG_HOME/procrastiproxy/config.yaml
+func configSearchPaths() []string {
+	paths := []string{defaultConfigFileName}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		// Per the XDG Base Directory specification, $HOME/.config is used when XDG_CONFIG_HOME is unset
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		paths = append(paths, filepath.Join(configHome, "procrastiproxy", "config.yaml"))
+	}
+	return paths
+}
+
+// findConfigFile returns the config file to load. An explicitly requested path must exist, but it is fine for there
+// to be no config file at any of the search paths, in which case an empty path is returned
+func findConfigFile(explicit string) (string, error) {
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", InvalidConfigError{Path: explicit, Underlying: err}
+		}
+		return explicit, nil
+	}
+	for _, path := range configSearchPaths() {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// LoadConfigFile reads the YAML config file at path. Unknown keys are rejected, so that typos don't silently go ignored
+func LoadConfigFile(path string) (Config, error) {
+	var c Config
+
+	f, err := os.Open(path)
+	if err != nil {
+		return c, InvalidConfigError{Path: path, Underlying: err}
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return c, InvalidConfigError{Path: path, Underlying: err}
+	}
+	return c, nil
+}
+
+// merge overrides the settings in c with each setting that is present in other
+func (c *Config) merge(other Config) {
+	if other.Port != "" {
+		c.Port = other.Port
+	}
+	if other.LogLevel != "" {
+		c.LogLevel = other.LogLevel
+	}
+	if other.Block != nil {
+		c.Block = other.Block
+	}
+	if other.BlockFiles != nil {
+		c.BlockFiles = other.BlockFiles
+	}
+	if other.Allow != nil {
+		c.Allow = other.Allow
+	}
+	if other.Mode != "" {
+		c.Mode = other.Mode
+	}
+	if other.URLRules != nil {
+		c.URLRules = other.URLRules
+	}
+	if other.ResolveHosts {
+		c.ResolveHosts = true
+	}
+	if other.BlockStartTime != "" {
+		c.BlockStartTime = other.BlockStartTime
+	}
+	if other.BlockEndTime != "" {
+		c.BlockEndTime = other.BlockEndTime
+	}
+	if other.BlockWindows != nil {
+		c.BlockWindows = other.BlockWindows
+	}
+	if other.Schedule != nil {
+		c.Schedule = other.Schedule
+	}
+	if other.HostSchedules != nil {
+		c.HostSchedules = other.HostSchedules
+	}
+	if other.Timezone != "" {
+		c.Timezone = other.Timezone
+	}
+	if other.StateFile != "" {
+		c.StateFile = other.StateFile
+	}
+	if other.ProxyAddress != "" {
+		c.ProxyAddress = other.ProxyAddress
+	}
+	if other.AdminAddress != "" {
+		c.AdminAddress = other.AdminAddress
+	}
+	if other.AdminToken != "" {
+		c.AdminToken = other.AdminToken
+	}
+	if other.AdminUsername != "" {
+		c.AdminUsername = other.AdminUsername
+	}
+	if other.AdminPassword != "" {
+		c.AdminPassword = other.AdminPassword
+	}
+}
+
+// mergeEnv overrides the settings in c with those supplied via environment variables
+func (c *Config) mergeEnv() {
+	c.merge(Config{
+		AdminToken:    os.Getenv(adminTokenEnv),
+		AdminPassword: os.Getenv(adminPasswordEnv),
+	})
+}
+
+// parsedConfig is a validated Config, converted into the forms procrastiproxy works with
+type parsedConfig struct {
+	list              *List
+	allowList         *List
+	mode              Mode
+	urlRules          []URLRule
+	proxyTimeSettings ProxyTimeSettings
+	adminAuth         AdminAuth
+}
+
+// parse validates every setting in the Config, reporting all of the problems it finds together
+func (c Config) parse() (parsedConfig, error) {
+	var result *multierror.Error
+	var parsed parsedConfig
+
+	if c.Port == "" {
+		result = multierror.Append(result, errors.New("You must supply a valid port via the --port flag"))
+	}
+
+	if _, levelErr := log.ParseLevel(c.LogLevel); levelErr != nil {
+		result = multierror.Append(result, fmt.Errorf("Invalid log level {%s}: %v", c.LogLevel, levelErr))
+	}
+
+	mode, modeErr := parseMode(c.Mode)
+	if modeErr != nil {
+		result = multierror.Append(result, modeErr)
+	}
+	parsed.mode = mode
+
+	var fileHosts []string
+	for _, path := range c.BlockFiles {
+		hosts, err := LoadBlockFile(path)
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+		fileHosts = append(fileHosts, hosts...)
+	}
+
+	parsed.list = NewList()
+	// In allow mode, the allow list alone can decide what is refused, so the block list may be empty
+	if mode != ModeAllow {
+		if err := validateBlockListInput(append(append(append([]string{}, c.Block...), c.HostSchedules...), fileHosts...)); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if err := validateNetworks("block", c.Block); err != nil {
+		result = multierror.Append(result, err)
+	}
+	AddHostToBlockList(parsed.list, c.Block...)
+	AddHostToBlockList(parsed.list, fileHosts...)
+	if err := parseHostSchedules(c.HostSchedules, parsed.list); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := validateNetworks("allow", c.Allow); err != nil {
+		result = multierror.Append(result, err)
+	}
+	parsed.allowList = NewList()
+	AddHostToBlockList(parsed.allowList, c.Allow...)
+
+	urlRules, err := parseURLRules(c.URLRules)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	parsed.urlRules = urlRules
+
+	pts, err := c.parseTimeSettings()
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	parsed.proxyTimeSettings = pts
+
+	if c.ProxyAddress != "" {
+		if _, _, addrErr := net.SplitHostPort(c.ProxyAddress); addrErr != nil {
+			result = multierror.Append(result, fmt.Errorf("Invalid proxy address {%s}. Supply a TCP address, e.g., 127.0.0.1:8000: %v", c.ProxyAddress, addrErr))
+		}
+	}
+	if c.AdminAddress != "" && !strings.HasPrefix(c.AdminAddress, unixSocketPrefix) {
+		if _, _, addrErr := net.SplitHostPort(c.AdminAddress); addrErr != nil {
+			result = multierror.Append(result, fmt.Errorf("Invalid admin address {%s}. Supply a TCP address, e.g., 127.0.0.1:8001, or a Unix socket, e.g., unix:/run/procrastiproxy/admin.sock: %v", c.AdminAddress, addrErr))
+		} else if addressesCollide(c.AdminAddress, c.proxyAddress()) {
+			result = multierror.Append(result, fmt.Errorf("Admin address {%s} uses the same port as the proxy address {%s}. Choose another port, or leave the admin address empty to serve the admin endpoints on the proxy's listener", c.AdminAddress, c.proxyAddress()))
+		}
+	}
+	if c.AdminUsername != "" && c.AdminPassword == "" {
+		result = multierror.Append(result, fmt.Errorf("Admin username {%s} was supplied without a password. Set admin_password or %s", c.AdminUsername, adminPasswordEnv))
+	}
+	parsed.adminAuth = AdminAuth{
+		Token:    c.AdminToken,
+		Username: c.AdminUsername,
+		Password: c.AdminPassword,
+	}
+	if parsed.adminAuth.Password != "" && parsed.adminAuth.Username == "" {
+		parsed.adminAuth.Username = defaultAdminUsername
+	}
+
+	return parsed, result.ErrorOrNil()
+}
+
+// proxyAddress returns the address the proxy will listen on: ProxyAddress when set, or else every interface on Port
+func (c Config) proxyAddress() string {
+	if c.ProxyAddress != "" {
+		return c.ProxyAddress
+	}
+	return ":" + c.Port
+}
+
+// parseTimeSettings validates the block times, windows, schedule and time zone of the Config, reporting all of the
+// problems it finds together
+func (c Config) parseTimeSettings() (ProxyTimeSettings, error) {
+	var result *multierror.Error
+
+	if err := parseStartAndEndTimes(c.BlockStartTime, c.BlockEndTime); err != nil {
+		result = multierror.Append(result, err)
+	}
+	pts := ProxyTimeSettings{
+		BlockStartTime: c.BlockStartTime,
+		BlockEndTime:   c.BlockEndTime,
+		DefaultLayout:  defaultLayout,
+	}
+
+	if len(c.BlockWindows) > 0 {
+		windows, err := parseBlockWindows(c.BlockWindows)
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+		pts.BlockWindows = windows
+	}
+
+	if len(c.Schedule) > 0 {
+		schedule, err := parseSchedule(strings.Join(c.Schedule, ";"))
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+		pts.Schedule = schedule
+	}
+
+	location, tzErr := loadTimezone(c.Timezone)
+	if tzErr != nil {
+		result = multierror.Append(result, tzErr)
+	}
+	pts.Timezone = c.Timezone
+	pts.location = location
+
+	// Parse the validated settings once, into the form requests are matched against
+	if result.ErrorOrNil() == nil {
+		if err := pts.compile(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return pts, result.ErrorOrNil()
+}
+
+// ApplyConfig validates the supplied Config and, only if it is entirely valid, replaces the proxy's port, block list and
+// time settings with it. It is safe to call while the proxy is serving requests, although a changed port only takes
+// effect on restart. Every problem found is reported together in the returned error
+func (p *Procrastiproxy) ApplyConfig(c Config) error {
+	parsed, err := c.parse()
+	if err != nil {
+		return err
+	}
+
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+
+	p.SetPort(c.Port)
+	p.ProxyAddress = c.ProxyAddress
+	p.SetAdminAddress(c.AdminAddress)
+	p.AdminAuth = parsed.adminAuth
+	if p.List == nil {
+		p.List = NewList()
+	}
+	if p.AllowList == nil {
+		p.AllowList = NewList()
+	}
+	// Swap the contents rather than the Lists themselves, as the admin endpoints and embedders may hold on to them
+	p.List.Replace(parsed.list)
+	p.AllowList.Replace(parsed.allowList)
+	p.Mode = parsed.mode
+	p.URLRules = parsed.urlRules
+	p.ResolveHosts = c.ResolveHosts
+	p.blockFiles = c.BlockFiles
+	// Changes made through the admin endpoints take precedence over the config
+	p.state.applyTo(p.List, p.AllowList)
+	p.timeSettings = parsed.proxyTimeSettings
+	return nil
+}
+
+// cliFlags holds the values of procrastiproxy's command line flags
+type cliFlags struct {
+	config         *string
+	port           *string
+	logLevel       *string
+	blockList      *string
+	blockFiles     repeatedFlag
+	allowList      *string
+	mode           *string
+	resolveHosts   *bool
+	blockStartTime *string
+	blockEndTime   *string
+	hostSchedules  repeatedFlag
+	blockWindows   repeatedFlag
+	urlRules       repeatedFlag
+	schedule       *string
+	timezone       *string
+	stateFile      *string
+	proxyAddress   *string
+	adminAddress   *string
+	adminUsername  *string
+}
+
+// registerFlags defines procrastiproxy's command line flags on fs
+func registerFlags(fs *flag.FlagSet) *cliFlags {
+	f := &cliFlags{}
+	f.config = fs.String("config", "", "Path to a YAML config file. Defaults to ./"+defaultConfigFileName+", then $XDG_CONFIG_HOME/procrastiproxy/config.yaml")
+	f.port = fs.String("port", "8000", "Port to listen on. Defaults to 8000")
+	f.logLevel = fs.String("loglevel", "info", "Log level. Defaults to Info")
+	f.blockList = fs.String("block", "", "Host to block. Defaults to none")
+	fs.Var(&f.blockFiles, "block-file", "File of hosts to block, with one host per line, or in /etc/hosts (0.0.0.0 reddit.com) or Adblock (||reddit.com^) format. May be repeated")
+	f.allowList = fs.String("allow", "", "Comma-separated hosts to permit during block windows, even if --block covers them. Defaults to none")
+	f.mode = fs.String("mode", string(ModeDeny), "Either deny, to refuse the hosts on the block list, or allow, to refuse every host that isn't on the allow list. Defaults to deny")
+	f.resolveHosts = fs.Bool("resolve-hosts", false, "Also refuse hostnames that resolve into a network on the block list, such as 151.101.0.0/16, at the cost of a DNS lookup per request. Defaults to false")
+	f.blockStartTime = fs.String("block-start-time", defaultBlockStartTime, "Start of business hours. Defaults to 9:00AM")
+	f.blockEndTime = fs.String("block-end-time", defaultBlockEndTime, "End of business hours. Defaults to 5:00PM")
+	fs.Var(&f.hostSchedules, "host-schedule", "Host to block on its own schedule, e.g., youtube.com@9:00AM-12:00PM or youtube.com@mon-fri=9:00AM-12:00PM;sat=off. May be repeated")
+	fs.Var(&f.urlRules, "url-rule", "Rule to block or permit requests by URL, e.g., youtube.com/shorts* or \"allow youtube.com/watch*\". Patterns are globs, or regular expressions prefixed with re:. May be repeated")
+	fs.Var(&f.blockWindows, "block-window", "Window to block during, e.g., 9:00AM-12:00PM. May be repeated, and overrides the block start and end times")
+	f.schedule = fs.String("schedule", "", "Per-weekday block windows, overriding the block start and end times. Example: mon-fri=9:00AM-5:00PM;sat=10:00AM-12:00PM;sun=off")
+	f.timezone = fs.String("timezone", "", "IANA time zone that block times are expressed in, e.g., America/New_York. Defaults to the system's local time zone")
+	f.stateFile = fs.String("state-file", defaultStatePath(), "File that block list changes made through the admin endpoints are saved to, so they survive restarts. Files ending in .yaml are written as YAML, and others as JSON. Pass an empty value to keep changes in memory only")
+	f.proxyAddress = fs.String("proxy-address", "", "Address for the proxy to listen on, e.g., 127.0.0.1:8000. Overrides --port")
+	f.adminAddress = fs.String("admin-address", "", "Address to serve the admin endpoints on, apart from the proxy, e.g., 127.0.0.1:8001 or unix:/run/procrastiproxy/admin.sock. Defaults to the proxy's own listener, where only requests addressed to procrastiproxy itself reach them")
+	f.adminUsername = fs.String("admin-username", "", "Username for HTTP basic auth to the admin endpoints. Defaults to "+defaultAdminUsername+". The password is read from $"+adminPasswordEnv+" or the config file")
+	return f
+}
+
+// override replaces the settings in c with the value of each flag that was explicitly passed on the command line
+func (f *cliFlags) override(fs *flag.FlagSet, c *Config) {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "port":
+			c.Port = *f.port
+		case "loglevel":
+			c.LogLevel = *f.logLevel
+		case "block":
+			c.Block = splitBlockList(*f.blockList)
+		case "block-file":
+			c.BlockFiles = f.blockFiles
+		case "allow":
+			c.Allow = splitBlockList(*f.allowList)
+		case "mode":
+			c.Mode = *f.mode
+		case "resolve-hosts":
+			c.ResolveHosts = *f.resolveHosts
+		case "block-start-time":
+			c.BlockStartTime = *f.blockStartTime
+		case "block-end-time":
+			c.BlockEndTime = *f.blockEndTime
+		case "host-schedule":
+			c.HostSchedules = f.hostSchedules
+		case "block-window":
+			c.BlockWindows = f.blockWindows
+		case "url-rule":
+			c.URLRules = f.urlRules
+		case "schedule":
+			c.Schedule = strings.Split(*f.schedule, ";")
+		case "timezone":
+			c.Timezone = *f.timezone
+		case "state-file":
+			c.StateFile = *f.stateFile
+		case "proxy-address":
+			c.ProxyAddress = *f.proxyAddress
+		case "admin-address":
+			c.AdminAddress = *f.adminAddress
+		case "admin-username":
+			c.AdminUsername = *f.adminUsername
+		}
+	})
+}
+
+// resolveConfig finds the config file, if there is one, and builds the effective Config from it. It returns the path
+// of the config file used
+func (f *cliFlags) resolveConfig(fs *flag.FlagSet) (Config, string, error) {
+	path, err := findConfigFile(*f.config)
+	if err != nil {
+		return DefaultConfig(), "", err
+	}
+	c, err := f.loadConfig(fs, path)
+	return c, path, err
+}
+
+// loadConfig builds the effective Config from the defaults, the config file at path, unless path is empty, and the
+// flags that were passed, in increasing order of precedence
+func (f *cliFlags) loadConfig(fs *flag.FlagSet, path string) (Config, error) {
+	c := DefaultConfig()
+
+	if path != "" {
+		fileConfig, err := LoadConfigFile(path)
+		if err != nil {
+			return c, err
+		}
+		c.merge(fileConfig)
+	}
+	c.mergeEnv()
+
+	f.override(fs, &c)
+	return c, nil
+}
@@ -0,0 +1,252 @@
+package procrastiproxy
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigYAML = `
+port: "8081"
+loglevel: debug
+timezone: America/New_York
+block:
+  - reddit.com
+  - nytimes.com
+block_windows:
+  - 9:00AM-12:00PM
+  - 1:00PM-5:00PM
+schedule:
+  - mon-fri=9:00AM-5:00PM
+  - sun=off
+host_schedules:
+  - youtube.com@9:00AM-12:00PM
+`
+
+// writeTestConfig writes contents to a config file in a temporary directory, returning its path
+func writeTestConfig(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, testConfigYAML)
+
+	c, err := LoadConfigFile(path)
+	require.NoError(t, err)
+
+	require.Equal(t, Config{
+		Port:          "8081",
+		LogLevel:      "debug",
+		Timezone:      "America/New_York",
+		Block:         []string{"reddit.com", "nytimes.com"},
+		BlockWindows:  []string{"9:00AM-12:00PM", "1:00PM-5:00PM"},
+		Schedule:      []string{"mon-fri=9:00AM-5:00PM", "sun=off"},
+		HostSchedules: []string{"youtube.com@9:00AM-12:00PM"},
+	}, c)
+}
+
+func TestLoadConfigFileRejectsUnknownKeys(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, "blok:\n  - reddit.com\n")
+
+	_, err := LoadConfigFile(path)
+	require.Error(t, err)
+
+	var configErr InvalidConfigError
+	require.True(t, errors.As(err, &configErr))
+	require.Equal(t, path, configErr.Path)
+}
+
+func TestLoadConfigFileAcceptsEmptyFile(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, "")
+
+	c, err := LoadConfigFile(path)
+	require.NoError(t, err)
+	require.Equal(t, Config{}, c)
+}
+
+// TestResolveConfigFlagsOverrideFile ensures that flags which were explicitly passed take precedence over the config
+// file, while the file takes precedence over flag defaults
+func TestResolveConfigFlagsOverrideFile(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, testConfigYAML)
+
+	fs := flag.NewFlagSet("procrastiproxy", flag.ContinueOnError)
+	flags := registerFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--config", path, "--port", "9000", "--block", "twitter.com,facebook.com"}))
+
+	c, usedPath, err := flags.resolveConfig(fs)
+	require.NoError(t, err)
+	require.Equal(t, path, usedPath)
+
+	// Overridden by flags
+	require.Equal(t, "9000", c.Port)
+	require.Equal(t, []string{"twitter.com", "facebook.com"}, c.Block)
+
+	// From the file, rather than the flag defaults
+	require.Equal(t, "debug", c.LogLevel)
+	require.Equal(t, "America/New_York", c.Timezone)
+
+	// From the defaults, as neither the file nor the flags set them
+	require.Equal(t, defaultBlockStartTime, c.BlockStartTime)
+	require.Equal(t, defaultBlockEndTime, c.BlockEndTime)
+}
+
+func TestResolveConfigFindsXDGConfigHome(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	path := writeTestConfig(t, configHome, filepath.Join("procrastiproxy", "config.yaml"), "block:\n  - reddit.com\n")
+
+	fs := flag.NewFlagSet("procrastiproxy", flag.ContinueOnError)
+	flags := registerFlags(fs)
+	require.NoError(t, fs.Parse(nil))
+
+	c, usedPath, err := flags.resolveConfig(fs)
+	require.NoError(t, err)
+	require.Equal(t, path, usedPath)
+	require.Equal(t, []string{"reddit.com"}, c.Block)
+}
+
+func TestResolveConfigRequiresExplicitFileToExist(t *testing.T) {
+	fs := flag.NewFlagSet("procrastiproxy", flag.ContinueOnError)
+	flags := registerFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}))
+
+	_, _, err := flags.resolveConfig(fs)
+	require.Error(t, err)
+}
+
+func TestApplyConfig(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, testConfigYAML)
+	c := DefaultConfig()
+	fileConfig, err := LoadConfigFile(path)
+	require.NoError(t, err)
+	c.merge(fileConfig)
+
+	p := NewProcrastiproxy()
+	require.NoError(t, p.ApplyConfig(c))
+
+	require.Equal(t, "8081", p.GetPort())
+	require.True(t, p.GetList().Contains("reddit.com"))
+	require.True(t, p.GetList().Contains("nytimes.com"))
+	require.NotNil(t, p.GetList().Schedule("youtube.com"))
+
+	// 10:00AM on a Monday in New York is within the schedule, and 10:00AM on a Sunday isn't
+	require.True(t, p.WithinBlockWindow(time.Date(2022, time.June, 6, 14, 0, 0, 0, time.UTC)))
+	require.False(t, p.WithinBlockWindow(time.Date(2022, time.June, 5, 14, 0, 0, 0, time.UTC)))
+}
+
+// TestApplyConfigReportsEveryProblem ensures that all invalid settings are reported together, and that the proxy is
+// left untouched
+func TestApplyConfigReportsEveryProblem(t *testing.T) {
+	c := Config{
+		Port:           "",
+		LogLevel:       "chatty",
+		BlockStartTime: "nine",
+		BlockEndTime:   "5:00PM",
+		BlockWindows:   []string{"9:00AM"},
+		Schedule:       []string{"someday=9:00AM-5:00PM"},
+		Timezone:       "Mars/Olympus_Mons",
+	}
+
+	p := NewProcrastiproxy()
+	p.SetPort("8000")
+
+	err := p.ApplyConfig(c)
+	require.Error(t, err)
+
+	var merr *multierror.Error
+	require.True(t, errors.As(err, &merr))
+	require.Len(t, merr.Errors, 7)
+
+	require.True(t, errors.As(err, &EmptyBlockListError{}))
+	require.True(t, errors.As(err, &InvalidTimezoneError{}))
+
+	require.Equal(t, "8000", p.GetPort())
+}
+
+func TestApplyConfigRejectsInvalidNetworks(t *testing.T) {
+	c := DefaultConfig()
+	c.Block = []string{"reddit.com", "151.101.0.0/33"}
+	c.Allow = []string{"10.0.0.0/8", "docs.reddit.com/guide"}
+
+	p := NewProcrastiproxy()
+	err := p.ApplyConfig(c)
+	require.Error(t, err)
+
+	var merr *multierror.Error
+	require.True(t, errors.As(err, &merr))
+	require.Len(t, merr.Errors, 2)
+	require.True(t, errors.As(err, &InvalidNetworkError{}))
+	require.Equal(t, 0, p.GetList().Length())
+}
+
+func TestApplyConfigLoadsBlockFiles(t *testing.T) {
+	dir := t.TempDir()
+	hostsFile := writeTestConfig(t, dir, "hosts", "0.0.0.0 reddit.com\n0.0.0.0 twitter.com\n")
+	adblockFile := writeTestConfig(t, dir, "adblock.txt", "! Distractions\n||youtube.com^\n||reddit.com^\n")
+
+	c := DefaultConfig()
+	c.BlockFiles = []string{hostsFile, adblockFile}
+	p := NewProcrastiproxy()
+	require.NoError(t, p.ApplyConfig(c))
+	require.ElementsMatch(t, []string{"reddit.com", "twitter.com", "youtube.com"}, p.GetList().All())
+
+	// Every invalid line of every file is reported, and the block list is left untouched
+	invalidFile := writeTestConfig(t, dir, "invalid.txt", "reddit.com\n||youtube.com^$popup\nreddit.com twitter.com\n")
+	c.BlockFiles = []string{invalidFile, filepath.Join(dir, "missing.txt")}
+	err := p.ApplyConfig(c)
+	var merr *multierror.Error
+	require.True(t, errors.As(err, &merr))
+	require.Len(t, merr.Errors, 3)
+	require.True(t, errors.As(err, &BlockFileError{}))
+	require.Contains(t, err.Error(), "line 2 of block file {"+invalidFile+"}")
+	require.Equal(t, 3, p.GetList().Length())
+}
+
+// TestApplyConfigRejectsAdminAddressCollision ensures that an admin address sharing the proxy's port is reported,
+// rather than failing when procrastiproxy starts listening
+func TestApplyConfigRejectsAdminAddressCollision(t *testing.T) {
+	// By default, the admin endpoints share the proxy's listener, so any port can be used
+	require.Empty(t, DefaultConfig().AdminAddress)
+
+	testCases := []struct {
+		Name         string
+		Port         string
+		ProxyAddress string
+		AdminAddress string
+		WantErr      bool
+	}{
+		{Name: "Same port as every interface", Port: "8001", AdminAddress: "127.0.0.1:8001", WantErr: true},
+		{Name: "Same proxy address", Port: "8000", ProxyAddress: "127.0.0.1:9000", AdminAddress: "127.0.0.1:9000", WantErr: true},
+		{Name: "Admin on every interface", Port: "8000", ProxyAddress: "127.0.0.1:9000", AdminAddress: ":9000", WantErr: true},
+		{Name: "Different ports", Port: "8000", AdminAddress: "127.0.0.1:8001"},
+		{Name: "Same port on different interfaces", Port: "8000", ProxyAddress: "127.0.0.1:9000", AdminAddress: "127.0.0.2:9000"},
+		{Name: "Unix socket", Port: "8001", AdminAddress: "unix:/tmp/procrastiproxy.sock"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.Block = []string{"reddit.com"}
+			c.Port = tc.Port
+			c.ProxyAddress = tc.ProxyAddress
+			c.AdminAddress = tc.AdminAddress
+
+			err := NewProcrastiproxy().ApplyConfig(c)
+			if tc.WantErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "uses the same port as the proxy address")
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
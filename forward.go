@@ -0,0 +1,256 @@
+package procrastiproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// viaPseudonym is how procrastiproxy identifies itself in the Via header of forwarded messages
+const viaPseudonym = "procrastiproxy"
+
+// hopByHopHeaders are meaningful only for a single transport-level connection, and must not be
+// forwarded by proxies. See RFC 7230, section 6.1
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// makeProxyRequest forwards the supplied request upstream, preserving its method, headers and body, and then
+// streams the upstream response - status code, headers, body and trailers - back to the caller. If the upstream
+// cannot be reached, the caller receives a gateway error and an UpstreamError is returned
+func (p *Procrastiproxy) makeProxyRequest(w http.ResponseWriter, r *http.Request) error {
+	outReq := r.Clone(r.Context())
+	// RequestURI must not be set on client requests
+	outReq.RequestURI = ""
+	if r.ContentLength == 0 {
+		// Avoid sending an empty chunked body upstream
+		outReq.Body = nil
+	}
+
+	removeHopByHopHeaders(outReq.Header)
+	// Te: trailers is the one hop-by-hop value we pass along, so that upstreams know trailers will reach the client
+	if headerContainsToken(r.Header, "Te", "trailers") {
+		outReq.Header.Set("Te", "trailers")
+	}
+	addForwardingHeaders(outReq, r)
+
+	// Use the transport directly rather than an http.Client, so that redirects are passed back to the caller
+	// instead of being followed on their behalf
+	res, err := p.transportFor(r).RoundTrip(outReq)
+	if err != nil {
+		upstreamErr := newUpstreamError(r.URL.Host, err)
+		writeUpstreamError(w, upstreamErr)
+		return upstreamErr
+	}
+	defer res.Body.Close()
+
+	removeHopByHopHeaders(res.Header)
+	copyHeader(w.Header(), res.Header)
+	addVia(w.Header(), res.ProtoMajor, res.ProtoMinor)
+
+	// Announce the trailers we expect to receive, so that they can be relayed after the body
+	if len(res.Trailer) > 0 {
+		trailerKeys := make([]string, 0, len(res.Trailer))
+		for k := range res.Trailer {
+			trailerKeys = append(trailerKeys, k)
+		}
+		w.Header().Set("Trailer", strings.Join(trailerKeys, ", "))
+	}
+
+	w.WriteHeader(res.StatusCode)
+
+	// The status has already been sent at this point, so all we can do is stop and report the failure
+	readErr, writeErr := copyResponseBody(w, res.Body)
+	if readErr != nil {
+		return newUpstreamError(r.URL.Host, readErr)
+	}
+	if writeErr != nil {
+		// The client stopped reading, usually by hanging up, which says nothing about the upstream
+		p.GetLogger().WithFields(logrus.Fields{
+			"Method": r.Method,
+			"URL":    r.URL.String(),
+			"Error":  writeErr,
+		}).Debug("Client went away before the response was delivered")
+		return nil
+	}
+
+	for k, vv := range res.Trailer {
+		for _, v := range vv {
+			w.Header().Add(http.TrailerPrefix+k, v)
+		}
+	}
+	return nil
+}
+
+// newUpstreamError wraps a failure to reach host in an UpstreamError of the appropriate kind
+func newUpstreamError(host string, err error) UpstreamError {
+	return UpstreamError{
+		Host:       host,
+		Kind:       classifyUpstreamError(err),
+		Underlying: err,
+	}
+}
+
+// classifyUpstreamError determines why an upstream request failed by inspecting the chain of wrapped errors
+func classifyUpstreamError(err error) UpstreamErrorKind {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return UpstreamTimeoutError
+		}
+		return UpstreamDNSError
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return UpstreamConnectionRefusedError
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return UpstreamTimeoutError
+	}
+
+	var (
+		recordHeaderErr   tls.RecordHeaderError
+		unknownAuthority  x509.UnknownAuthorityError
+		hostnameErr       x509.HostnameError
+		invalidCertErr    x509.CertificateInvalidError
+		systemRootsErr    x509.SystemRootsError
+		constraintViolErr x509.ConstraintViolationError
+	)
+	if errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidCertErr) ||
+		errors.As(err, &systemRootsErr) ||
+		errors.As(err, &constraintViolErr) {
+		return UpstreamTLSError
+	}
+
+	return UpstreamUnknownError
+}
+
+// writeUpstreamError sends the caller a gateway error describing why their request could not be completed
+func writeUpstreamError(w http.ResponseWriter, err UpstreamError) {
+	http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(err.StatusCode()), err.Error()), err.StatusCode())
+}
+
+// logUpstreamError records a failed upstream request with enough structured context to diagnose it
+func (p *Procrastiproxy) logUpstreamError(r *http.Request, err error) {
+	fields := logrus.Fields{
+		"Method": r.Method,
+		"URL":    r.URL.String(),
+		"Error":  err,
+	}
+	var upstreamErr UpstreamError
+	if errors.As(err, &upstreamErr) {
+		fields["Host"] = upstreamErr.Host
+		fields["Kind"] = upstreamErr.Kind
+		fields["Status"] = upstreamErr.StatusCode()
+	}
+	p.GetLogger().WithFields(fields).Warn("Upstream request failed")
+}
+
+// copyResponseBody streams src to w, flushing after each write so that long-lived or incremental responses
+// reach the caller as they arrive rather than once the upstream has finished. Failing to read src is the upstream's
+// fault, while failing to write to w is the caller's, so the two are returned separately
+func copyResponseBody(w http.ResponseWriter, src io.Reader) (readErr, writeErr error) {
+	flusher, canFlush := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return nil, writeErr
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+}
+
+// removeHopByHopHeaders deletes the standard hop-by-hop headers, as well as any additional headers the sender
+// nominated as hop-by-hop by listing them in the Connection header
+func removeHopByHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, field := range strings.Split(v, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				h.Del(field)
+			}
+		}
+	}
+	for _, hh := range hopByHopHeaders {
+		h.Del(hh)
+	}
+}
+
+// headerContainsToken reports whether the comma-separated header key contains token, ignoring case
+func headerContainsToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addForwardingHeaders records this hop on the outbound request via the Via and X-Forwarded-For headers
+func addForwardingHeaders(outReq, r *http.Request) {
+	addVia(outReq.Header, r.ProtoMajor, r.ProtoMinor)
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return
+	}
+	if prior := outReq.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		clientIP = strings.Join(prior, ", ") + ", " + clientIP
+	}
+	outReq.Header.Set("X-Forwarded-For", clientIP)
+}
+
+// addVia appends procrastiproxy to the Via header, using the protocol version of the message being forwarded
+func addVia(h http.Header, major, minor int) {
+	proto := "1.1"
+	switch {
+	case major >= 2:
+		proto = strconv.Itoa(major)
+	case major == 1:
+		proto = fmt.Sprintf("%d.%d", major, minor)
+	}
+	h.Add("Via", proto+" "+viaPseudonym)
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
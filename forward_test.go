@@ -0,0 +1,294 @@
+package procrastiproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMakeProxyRequestForwardsFaithfully ensures that the request method, body and end-to-end headers reach the
+// upstream, and that the upstream's status, headers, body and trailers make it back to the caller
+func TestMakeProxyRequestForwardsFaithfully(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "name=procrastiproxy", string(body))
+		assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+		assert.Equal(t, "keep-me", r.Header.Get("X-End-To-End"))
+		assert.Empty(t, r.Header.Get("X-Per-Hop"))
+		assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+		assert.Contains(t, r.Header.Get("Via"), viaPseudonym)
+		assert.Equal(t, "127.0.0.1", r.Header.Get("X-Forwarded-For"))
+
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Location", "/created/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer upstream.Close()
+
+	p := NewProcrastiproxy()
+	proxy := httptest.NewServer(http.HandlerFunc(p.proxyHandler))
+	defer proxy.Close()
+
+	proxyURL, parseErr := url.Parse(proxy.URL)
+	require.NoError(t, parseErr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	req, reqErr := http.NewRequest(http.MethodPost, upstream.URL+"/forms", strings.NewReader("name=procrastiproxy"))
+	require.NoError(t, reqErr)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-End-To-End", "keep-me")
+	req.Header.Set("X-Per-Hop", "drop-me")
+	req.Header.Set("Connection", "X-Per-Hop")
+	req.Header.Set("Proxy-Authorization", "Basic c2VjcmV0")
+
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, readErr := ioutil.ReadAll(res.Body)
+	require.NoError(t, readErr)
+
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	require.Equal(t, "created", string(body))
+	require.Equal(t, "yes", res.Header.Get("X-Upstream"))
+	require.Equal(t, "/created/1", res.Header.Get("Location"))
+	require.Contains(t, res.Header.Get("Via"), viaPseudonym)
+	require.Equal(t, "abc123", res.Trailer.Get("X-Checksum"))
+}
+
+// TestMakeProxyRequestDoesNotFollowRedirects ensures redirects are returned to the caller rather than followed
+func TestMakeProxyRequestDoesNotFollowRedirects(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	r := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+	w := httptest.NewRecorder()
+
+	NewProcrastiproxy().makeProxyRequest(w, r)
+
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "/elsewhere", w.Header().Get("Location"))
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "close, X-Custom-Hop")
+	h.Set("X-Custom-Hop", "1")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Upgrade", "websocket")
+	h.Set("Accept", "text/html")
+
+	removeHopByHopHeaders(h)
+
+	require.Equal(t, http.Header{"Accept": []string{"text/html"}}, h)
+}
+
+func TestAddForwardingHeadersAppendsToExistingChain(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	r.RemoteAddr = "10.0.0.5:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	outReq := r.Clone(r.Context())
+	addForwardingHeaders(outReq, r)
+
+	require.Equal(t, "203.0.113.7, 10.0.0.5", outReq.Header.Get("X-Forwarded-For"))
+	require.Equal(t, "1.1 "+viaPseudonym, outReq.Header.Get("Via"))
+}
+
+// timeoutError satisfies net.Error, reporting a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyUpstreamError(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Err  error
+		Want UpstreamErrorKind
+	}{
+		{
+			Name: "DNS lookup failure",
+			Err:  &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "reddit.invalid", IsNotFound: true}},
+			Want: UpstreamDNSError,
+		},
+		{
+			Name: "DNS lookup timeout",
+			Err:  &net.OpError{Op: "dial", Err: &net.DNSError{Err: "timeout", Name: "reddit.com", IsTimeout: true}},
+			Want: UpstreamTimeoutError,
+		},
+		{
+			Name: "Connection refused",
+			Err:  &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			Want: UpstreamConnectionRefusedError,
+		},
+		{
+			Name: "Network timeout",
+			Err:  &url.Error{Op: "Get", URL: "http://reddit.com", Err: timeoutError{}},
+			Want: UpstreamTimeoutError,
+		},
+		{
+			Name: "Context deadline exceeded",
+			Err:  fmt.Errorf("round trip: %w", context.DeadlineExceeded),
+			Want: UpstreamTimeoutError,
+		},
+		{
+			Name: "Untrusted certificate",
+			Err:  fmt.Errorf("handshake: %w", x509.UnknownAuthorityError{}),
+			Want: UpstreamTLSError,
+		},
+		{
+			Name: "Wrong host in certificate",
+			Err:  fmt.Errorf("handshake: %w", x509.HostnameError{Certificate: &x509.Certificate{}, Host: "reddit.com"}),
+			Want: UpstreamTLSError,
+		},
+		{
+			Name: "Malformed TLS record",
+			Err:  &url.Error{Op: "Get", URL: "https://reddit.com", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}},
+			Want: UpstreamTLSError,
+		},
+		{
+			Name: "Untyped failure that merely mentions TLS",
+			Err:  errors.New("tls: something went sideways"),
+			Want: UpstreamUnknownError,
+		},
+		{
+			Name: "Unrecognized failure",
+			Err:  errors.New("something went sideways"),
+			Want: UpstreamUnknownError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Want, classifyUpstreamError(tc.Err))
+		})
+	}
+}
+
+// TestMakeProxyRequestReturnsGatewayErrors ensures that unreachable upstreams produce gateway errors for the caller
+// and a typed error for the handler, rather than bringing the proxy down
+func TestMakeProxyRequestReturnsGatewayErrors(t *testing.T) {
+	t.Parallel()
+
+	// Grab a port that is guaranteed to have nothing listening on it
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer untrusted.Close()
+
+	testCases := []struct {
+		Name       string
+		URL        string
+		WantKind   UpstreamErrorKind
+		WantStatus int
+	}{
+		{
+			Name:       "Connection refused",
+			URL:        closedURL,
+			WantKind:   UpstreamConnectionRefusedError,
+			WantStatus: http.StatusBadGateway,
+		},
+		{
+			Name:       "Unresolvable host",
+			URL:        "http://procrastiproxy.invalid",
+			WantKind:   UpstreamDNSError,
+			WantStatus: http.StatusBadGateway,
+		},
+		{
+			Name:       "Untrusted certificate",
+			URL:        untrusted.URL,
+			WantKind:   UpstreamTLSError,
+			WantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.URL, nil)
+			w := httptest.NewRecorder()
+
+			err := NewProcrastiproxy().makeProxyRequest(w, r)
+
+			var upstreamErr UpstreamError
+			require.True(t, errors.As(err, &upstreamErr), "expected UpstreamError but got %T: %v", err, err)
+			require.Equal(t, tc.WantKind, upstreamErr.Kind)
+			require.Equal(t, tc.WantStatus, w.Code)
+			require.Contains(t, w.Body.String(), http.StatusText(tc.WantStatus))
+		})
+	}
+}
+
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, syscall.EPIPE }
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, syscall.ECONNRESET }
+
+// TestCopyResponseBodySeparatesFailures ensures that a caller who stops reading is not mistaken for a failing upstream
+func TestCopyResponseBodySeparatesFailures(t *testing.T) {
+	readErr, writeErr := copyResponseBody(failingWriter{httptest.NewRecorder()}, strings.NewReader("hello"))
+	require.NoError(t, readErr)
+	require.Error(t, writeErr)
+
+	readErr, writeErr = copyResponseBody(httptest.NewRecorder(), failingReader{})
+	require.Error(t, readErr)
+	require.NoError(t, writeErr)
+
+	readErr, writeErr = copyResponseBody(httptest.NewRecorder(), strings.NewReader("hello"))
+	require.NoError(t, readErr)
+	require.NoError(t, writeErr)
+}
+
+// TestMakeProxyRequestIgnoresClientDisconnects ensures that failing to deliver the body to the caller is not reported
+// as an upstream failure
+func TestMakeProxyRequestIgnoresClientDisconnects(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer upstream.Close()
+
+	r := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+	w := failingWriter{httptest.NewRecorder()}
+
+	require.NoError(t, NewProcrastiproxy().makeProxyRequest(w, r))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUpstreamErrorStatusCode(t *testing.T) {
+	require.Equal(t, http.StatusGatewayTimeout, UpstreamError{Kind: UpstreamTimeoutError}.StatusCode())
+	require.Equal(t, http.StatusBadGateway, UpstreamError{Kind: UpstreamDNSError}.StatusCode())
+	require.Equal(t, http.StatusBadGateway, UpstreamError{Kind: UpstreamUnknownError}.StatusCode())
+}
@@ -3,7 +3,10 @@ module github.com/zackproser/procrastiproxy
 go 1.14
 
 require (
+	github.com/fsnotify/fsnotify v1.5.4
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/sirupsen/logrus v1.8.1
-	golang.org/x/sys v0.0.0-20220624220833-87e55d714810 // indirect
+	github.com/stretchr/testify v1.2.2
+	golang.org/x/net v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
 )
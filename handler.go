@@ -0,0 +1,52 @@
+package procrastiproxy
+
+import (
+	"net/http"
+)
+
+// Middleware wraps an http.Handler, as is conventional for Go HTTP middleware
+type Middleware func(http.Handler) http.Handler
+
+// AfterDecisionHook is called with each request and the decision made about it, before the decision is carried out.
+// Hooks observe decisions, for example to log or count them, but cannot change them
+type AfterDecisionHook func(r *http.Request, d Decision)
+
+// WithBeforeDecision wraps the proxy's handler in middleware that runs before each request is checked against the
+// block list. Middleware may add to the request's context, or respond itself and stop the request going any further,
+// as authentication middleware does. The first middleware listed runs first
+func WithBeforeDecision(middleware ...Middleware) Option {
+	return func(p *Procrastiproxy) {
+		p.beforeHooks = append(p.beforeHooks, middleware...)
+	}
+}
+
+// WithAfterDecision calls hooks with every decision the proxy makes, in the order they are listed
+func WithAfterDecision(hooks ...AfterDecisionHook) Option {
+	return func(p *Procrastiproxy) {
+		p.afterHooks = append(p.afterHooks, hooks...)
+	}
+}
+
+// Handler returns the proxy as an http.Handler, so that it can be mounted in another server or middleware chain. It
+// blocks or forwards each request it receives, and serves nothing else: mount AdminHandler separately
+func (p *Procrastiproxy) Handler() http.Handler {
+	var h http.Handler = http.HandlerFunc(p.timeAwareHandler)
+	for i := len(p.beforeHooks) - 1; i >= 0; i-- {
+		h = p.beforeHooks[i](h)
+	}
+	return h
+}
+
+// AdminHandler returns the admin endpoints, which are served under /admin/ and /api/v1/, as an http.Handler. It
+// requires the proxy's admin credentials, if any are configured. To mount it under another path, wrap it in
+// http.StripPrefix
+func (p *Procrastiproxy) AdminHandler() http.Handler {
+	return p.adminRoutes()
+}
+
+// afterDecision calls each AfterDecisionHook with the decision made about r
+func (p *Procrastiproxy) afterDecision(r *http.Request, d Decision) {
+	for _, hook := range p.afterHooks {
+		hook(r, d)
+	}
+}
@@ -0,0 +1,100 @@
+package procrastiproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerMiddlewareHooks(t *testing.T) {
+	testHost, testURL := newTestUpstream(t)
+
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	requireProxyAuth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Proxy-Authorization") != "Bearer s3cret" {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	var m sync.Mutex
+	var decisions []Decision
+	p := newAlwaysBlockingProxy(testAdminAddress, "reddit.com")
+	WithBeforeDecision(trace("first"), trace("second"), requireProxyAuth)(p)
+	WithAfterDecision(func(r *http.Request, d Decision) {
+		m.Lock()
+		defer m.Unlock()
+		decisions = append(decisions, d)
+	})(p)
+
+	ts := httptest.NewServer(p.Handler())
+	defer ts.Close()
+	proxyURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:              http.ProxyURL(proxyURL),
+		ProxyConnectHeader: http.Header{"Proxy-Authorization": {"Bearer s3cret"}},
+	}}
+
+	// Middleware can refuse a request before any decision is made
+	resp, err := client.Get(testURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+	require.Empty(t, decisions)
+	require.Equal(t, []string{"first", "second"}, order)
+
+	get := func(target string) int {
+		r, err := http.NewRequest(http.MethodGet, target, nil)
+		require.NoError(t, err)
+		r.Header.Set("Proxy-Authorization", "Bearer s3cret")
+		resp, err := client.Do(r)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, get(testURL))
+	require.Equal(t, http.StatusForbidden, get("http://reddit.com/"))
+
+	m.Lock()
+	defer m.Unlock()
+	require.Len(t, decisions, 2)
+	require.Equal(t, testHost, decisions[0].Host)
+	require.True(t, decisions[0].InBlockWindow)
+	require.False(t, decisions[0].Blocked)
+	require.Equal(t, "reddit.com", decisions[1].Host)
+	require.True(t, decisions[1].Blocked)
+}
+
+func TestAdminHandlerMountsInOwnMux(t *testing.T) {
+	p := NewProcrastiproxy(WithAdminAuth(AdminAuth{Token: "s3cret"}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/procrastiproxy/", http.StripPrefix("/procrastiproxy", p.AdminHandler()))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/procrastiproxy/api/v1/blocklist", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/procrastiproxy/admin/block/reddit.com", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	mux.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, p.GetList().Contains("reddit.com"))
+}
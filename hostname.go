@@ -0,0 +1,64 @@
+package procrastiproxy
+
+import (
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts domains into the ASCII form they are looked up in, folding case and compatibility characters,
+// such as fullwidth letters and ideographic full stops, as browsers do. Underscores and the * of wildcard entries
+// appear in real block lists, so they are let through rather than rejected
+var idnaProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.Transitional(false))
+
+// canonicalHost returns host, which may have a port, in the form hosts are compared in, so that one domain can't be
+// reached by an alternate spelling: internationalized domains in their ASCII (punycode) form, in lower case, and
+// without a trailing dot. bücher.de, BÜCHER.de and xn--bcher-kva.de. all become xn--bcher-kva.de. IP addresses,
+// networks, and hosts that aren't valid domain names are returned unchanged
+func canonicalHost(host string) string {
+	if host == "" || strings.Contains(host, "/") {
+		return host
+	}
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if net.ParseIP(h) != nil {
+			return host
+		}
+		return net.JoinHostPort(canonicalDomain(h), port)
+	}
+	if net.ParseIP(stripPort(host)) != nil {
+		return host
+	}
+	return canonicalDomain(host)
+}
+
+// canonicalDomain converts a domain without a port, which may be a wildcard domain, into its canonical form
+func canonicalDomain(domain string) string {
+	wildcard := strings.HasPrefix(domain, "*.")
+	if wildcard {
+		domain = strings.TrimPrefix(domain, "*.")
+	}
+
+	domain = strings.TrimRight(domain, ".")
+	// ASCII domains without punycode labels are already canonical once lower case, so skip the conversion for them
+	if !isASCII(domain) || strings.Contains(domain, "xn--") {
+		if ascii, err := idnaProfile.ToASCII(domain); err == nil {
+			domain = strings.TrimRight(ascii, ".")
+		}
+	}
+
+	if wildcard {
+		return "*." + domain
+	}
+	return domain
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package procrastiproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeHost(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Host string
+		Want string
+	}{
+		{Name: "Plain domain", Host: "reddit.com", Want: "reddit.com"},
+		{Name: "Case", Host: "ReDDit.COM", Want: "reddit.com"},
+		{Name: "Whitespace and newlines", Host: " reddit.com\n", Want: "reddit.com"},
+		{Name: "Trailing dot", Host: "reddit.com.", Want: "reddit.com"},
+		{Name: "Trailing dot with port", Host: "reddit.com.:443", Want: "reddit.com:443"},
+		{Name: "Unicode domain", Host: "bücher.de", Want: "xn--bcher-kva.de"},
+		{Name: "Mixed case Unicode domain", Host: "BÜCHER.de", Want: "xn--bcher-kva.de"},
+		{Name: "Mixed case punycode", Host: "XN--BCHER-KVA.DE", Want: "xn--bcher-kva.de"},
+		{Name: "Punycode with trailing dot", Host: "xn--bcher-kva.de.", Want: "xn--bcher-kva.de"},
+		{Name: "Unicode domain with port", Host: "bücher.de:8080", Want: "xn--bcher-kva.de:8080"},
+		{Name: "Unicode wildcard", Host: "*.Bücher.de", Want: "*.xn--bcher-kva.de"},
+		{Name: "Fullwidth letters", Host: "ｒｅｄｄｉｔ.com", Want: "reddit.com"},
+		{Name: "Ideographic full stop", Host: "reddit。com", Want: "reddit.com"},
+		{Name: "Unicode subdomain of ASCII domain", Host: "müller.example.com", Want: "xn--mller-kva.example.com"},
+		{Name: "Sharp s is not transitionally mapped", Host: "faß.de", Want: "xn--fa-hia.de"},
+		{Name: "Underscore", Host: "my_host.example.com", Want: "my_host.example.com"},
+		{Name: "Host and port", Host: "localhost:8080", Want: "localhost:8080"},
+		{Name: "IPv4 address", Host: "10.0.0.1:80", Want: "10.0.0.1:80"},
+		{Name: "IPv6 address", Host: "[2001:DB8::1]:443", Want: "[2001:db8::1]:443"},
+		{Name: "Network", Host: "151.101.0.0/16", Want: "151.101.0.0/16"},
+		{Name: "Invalid punycode is left alone", Host: "xn--a.com", Want: "xn--a.com"},
+		{Name: "Empty", Host: "", Want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Want, sanitizeHost(tc.Host))
+		})
+	}
+}
+
+// TestAlternateSpellingsAreBlocked ensures that a blocked domain can't be reached by spelling it differently, whichever
+// spelling it was added to the block list with
+func TestAlternateSpellingsAreBlocked(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Entry  string
+		Target string
+	}{
+		{Name: "Trailing dot", Entry: "reddit.com", Target: "http://reddit.com./"},
+		{Name: "Trailing dot on subdomain with port", Entry: "reddit.com", Target: "http://old.reddit.com.:80/"},
+		{Name: "Punycode request for Unicode entry", Entry: "bücher.de", Target: "http://xn--bcher-kva.de/"},
+		{Name: "Unicode request for punycode entry", Entry: "xn--bcher-kva.de", Target: "http://bücher.de/"},
+		{Name: "Mixed case Unicode request", Entry: "bücher.de", Target: "http://BÜCHER.DE/"},
+		{Name: "Mixed case punycode request", Entry: "bücher.de", Target: "http://XN--BCHER-KVA.de/"},
+		{Name: "Percent-encoded Unicode request", Entry: "bücher.de", Target: "http://b%C3%BCcher.de/"},
+		{Name: "Fullwidth request", Entry: "reddit.com", Target: "http://ｒｅｄｄｉｔ.com/"},
+		{Name: "Entry with trailing dot", Entry: "reddit.com.", Target: "http://www.reddit.com/"},
+		{Name: "Unicode subdomain of wildcard entry", Entry: "*.bücher.de", Target: "http://www.xn--bcher-kva.de/"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			p.GetList().Add(tc.Entry)
+
+			r := httptest.NewRequest(http.MethodGet, tc.Target, nil)
+			w := httptest.NewRecorder()
+			http.HandlerFunc(p.blockListAwareHandler).ServeHTTP(w, r)
+			require.Equal(t, http.StatusForbidden, w.Code, "expected %s to be blocked by %s", tc.Target, tc.Entry)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package procrastiproxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ipTrie stores IP networks in a binary radix tree keyed by the bits of their address, so that finding the most
+// specific network containing an address costs one step per bit of its prefix, no matter how many networks are
+// stored. IPv4 networks are stored in their IPv4-mapped IPv6 form, so that both address families share one tree
+type ipTrie struct {
+	root *ipTrieNode
+	size int
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	// entry is the list entry for the network ending at this node, e.g., 151.101.0.0/16, or empty if there is none
+	entry string
+}
+
+func newIPTrie() *ipTrie {
+	return &ipTrie{root: &ipTrieNode{}}
+}
+
+// parseNetwork parses an entry that is either a network in CIDR notation (151.101.0.0/16, 2a04:4e42::/32) or a single
+// IP address, which is treated as a network containing only that address. It returns the entry in canonical form,
+// e.g., 151.101.0.0/16 for 151.101.7.7/16, and reports false for anything else, such as a domain
+func parseNetwork(entry string) (string, *net.IPNet, bool) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return "", nil, false
+		}
+		return network.String(), network, true
+	}
+
+	ip := net.ParseIP(stripPort(entry))
+	if ip == nil || hasPort(entry) {
+		return "", nil, false
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return ip.String(), &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, true
+}
+
+// networkKey returns the 16 byte form of network's address, along with the length of its prefix within it
+func networkKey(network *net.IPNet) (net.IP, int) {
+	ones, bits := network.Mask.Size()
+	if bits == 8*net.IPv4len {
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	}
+	return network.IP.To16(), ones
+}
+
+// ipBit returns the bit of ip at position i, counting from the most significant bit of the first byte
+func ipBit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
+
+// insert adds network to the tree, recording entry as the list entry it came from
+func (t *ipTrie) insert(network *net.IPNet, entry string) {
+	ip, ones := networkKey(network)
+	node := t.root
+	for i := 0; i < ones; i++ {
+		b := ipBit(ip, i)
+		if node.children[b] == nil {
+			node.children[b] = &ipTrieNode{}
+		}
+		node = node.children[b]
+	}
+	if node.entry == "" {
+		t.size++
+	}
+	node.entry = entry
+}
+
+// remove deletes a network previously passed to insert, pruning any branches left empty
+func (t *ipTrie) remove(network *net.IPNet) {
+	ip, ones := networkKey(network)
+	path := []*ipTrieNode{t.root}
+	node := t.root
+	for i := 0; i < ones; i++ {
+		node = node.children[ipBit(ip, i)]
+		if node == nil {
+			return
+		}
+		path = append(path, node)
+	}
+	if node.entry == "" {
+		return
+	}
+	node.entry = ""
+	t.size--
+
+	// Walk back up towards the root, removing nodes that no longer hold an entry or lead to one
+	for i := ones; i > 0; i-- {
+		n := path[i]
+		if n.entry != "" || n.children[0] != nil || n.children[1] != nil {
+			return
+		}
+		path[i-1].children[ipBit(ip, i-1)] = nil
+	}
+}
+
+// match returns the entry of the most specific network containing ip, if any
+func (t *ipTrie) match(ip net.IP) (string, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return "", false
+	}
+
+	var matched string
+	node := t.root
+	for i := 0; node != nil; i++ {
+		if node.entry != "" {
+			matched = node.entry
+		}
+		if i == 8*net.IPv6len {
+			break
+		}
+		node = node.children[ipBit(ip, i)]
+	}
+	return matched, matched != ""
+}
+
+type InvalidNetworkError struct {
+	FlagName   string
+	Value      string
+	Underlying error
+}
+
+func (err InvalidNetworkError) Error() string {
+	return fmt.Sprintf("Invalid network {%s} passed with flag {%s}: %v. Networks must be in CIDR notation, e.g., 151.101.0.0/16 or 2a04:4e42::/32", err.Value, err.FlagName, err.Underlying)
+}
+
+func (err InvalidNetworkError) Unwrap() error {
+	return err.Underlying
+}
+
+// validateNetworks checks that every entry that looks like a network, by containing a /, is one, reporting every
+// invalid entry together
+func validateNetworks(flagName string, entries []string) error {
+	var result *multierror.Error
+	for _, entry := range entries {
+		entry = sanitizeHost(entry)
+		if !strings.Contains(entry, "/") {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			result = multierror.Append(result, InvalidNetworkError{FlagName: flagName, Value: entry, Underlying: err})
+		}
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,89 @@
+package procrastiproxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetwork(t *testing.T) {
+	testCases := []struct {
+		Entry         string
+		WantCanonical string
+		WantOK        bool
+	}{
+		{Entry: "151.101.0.0/16", WantCanonical: "151.101.0.0/16", WantOK: true},
+		{Entry: "151.101.7.7/16", WantCanonical: "151.101.0.0/16", WantOK: true},
+		{Entry: "2A04:4E42::/32", WantCanonical: "2a04:4e42::/32", WantOK: true},
+		{Entry: "10.0.0.1", WantCanonical: "10.0.0.1", WantOK: true},
+		{Entry: "2001:0db8::0001", WantCanonical: "2001:db8::1", WantOK: true},
+		{Entry: "[2001:db8::1]", WantCanonical: "2001:db8::1", WantOK: true},
+		{Entry: "10.0.0.1:8080", WantOK: false},
+		{Entry: "151.101.0.0/33", WantOK: false},
+		{Entry: "reddit.com", WantOK: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Entry, func(t *testing.T) {
+			canonical, _, ok := parseNetwork(tc.Entry)
+			require.Equal(t, tc.WantOK, ok)
+			require.Equal(t, tc.WantCanonical, canonical)
+		})
+	}
+}
+
+func TestIPTrieMatch(t *testing.T) {
+	trie := newIPTrie()
+	for _, entry := range []string{"151.101.0.0/16", "151.101.64.0/18", "10.0.0.1", "2a04:4e42::/32", "0.0.0.0/0"} {
+		_, network, ok := parseNetwork(entry)
+		require.True(t, ok)
+		trie.insert(network, entry)
+	}
+
+	testCases := []struct {
+		IP        string
+		WantEntry string
+		WantMatch bool
+	}{
+		{IP: "151.101.1.69", WantEntry: "151.101.0.0/16", WantMatch: true},
+		{IP: "151.101.65.69", WantEntry: "151.101.64.0/18", WantMatch: true},
+		{IP: "::ffff:151.101.1.69", WantEntry: "151.101.0.0/16", WantMatch: true},
+		{IP: "10.0.0.1", WantEntry: "10.0.0.1", WantMatch: true},
+		{IP: "10.0.0.2", WantEntry: "0.0.0.0/0", WantMatch: true},
+		{IP: "2a04:4e42:200::323", WantEntry: "2a04:4e42::/32", WantMatch: true},
+		// 0.0.0.0/0 covers every IPv4 address, but no IPv6 addresses
+		{IP: "2a04:4e43::1", WantMatch: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.IP, func(t *testing.T) {
+			entry, ok := trie.match(net.ParseIP(tc.IP))
+			require.Equal(t, tc.WantMatch, ok)
+			require.Equal(t, tc.WantEntry, entry)
+		})
+	}
+}
+
+func TestIPTrieRemovePrunesEmptyBranches(t *testing.T) {
+	trie := newIPTrie()
+	_, wide, _ := parseNetwork("151.101.0.0/16")
+	_, narrow, _ := parseNetwork("151.101.64.0/18")
+	trie.insert(wide, "151.101.0.0/16")
+	trie.insert(narrow, "151.101.64.0/18")
+	require.Equal(t, 2, trie.size)
+
+	trie.remove(wide)
+	require.Equal(t, 1, trie.size)
+	_, ok := trie.match(net.ParseIP("151.101.1.69"))
+	require.False(t, ok)
+	entry, ok := trie.match(net.ParseIP("151.101.65.69"))
+	require.True(t, ok)
+	require.Equal(t, "151.101.64.0/18", entry)
+
+	// Removing a network that isn't present changes nothing
+	trie.remove(wide)
+	require.Equal(t, 1, trie.size)
+
+	trie.remove(narrow)
+	require.Equal(t, 0, trie.size)
+	require.Equal(t, [2]*ipTrieNode{}, trie.root.children)
+}
@@ -0,0 +1,331 @@
+package procrastiproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultShutdownTimeout bounds how long Start waits for requests and tunnels to finish once its context is done
+var defaultShutdownTimeout = 30 * time.Second
+
+// lifecycle tracks the servers started by Start, so that Shutdown can stop them
+type lifecycle struct {
+	mu            sync.Mutex
+	started       bool
+	proxyServer   *http.Server
+	adminServer   *http.Server
+	proxyListener net.Listener
+	adminListener net.Listener
+	tunnels       *tunnelTracker
+
+	// serveErrs receives any error that stops a server unexpectedly
+	serveErrs chan error
+	// stopped is closed once Shutdown has finished
+	stopped chan struct{}
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+type ServerAlreadyStartedError struct{}
+
+func (err ServerAlreadyStartedError) Error() string {
+	return "Procrastiproxy has already been started. Create a new Procrastiproxy to serve again"
+}
+
+// Start begins listening on the proxy's address and, if it has one, its admin address, and serves requests in the
+// background. It returns once the listeners are open, reporting any failure to open them. When ctx is done, the proxy
+// is shut down, giving requests and tunnels that are underway defaultShutdownTimeout to finish. Use Wait to block
+// until the proxy has stopped
+func (p *Procrastiproxy) Start(ctx context.Context) error {
+	lc := &p.lifecycle
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if lc.started {
+		return ServerAlreadyStartedError{}
+	}
+
+	proxyServer, adminServer := p.newServers()
+	tunnels := newTunnelTracker()
+	// Tunnels hijack their connections from the server, which then no longer tracks them, so we do it instead
+	proxyServer.BaseContext = func(net.Listener) context.Context {
+		return withTunnelTracker(context.Background(), tunnels)
+	}
+
+	// Listeners supplied via options are used as they are
+	proxyListener := p.listener
+	if proxyListener == nil {
+		var err error
+		if proxyListener, err = net.Listen("tcp", proxyServer.Addr); err != nil {
+			return err
+		}
+	}
+
+	adminListener := p.adminListener
+	if adminServer != nil && adminListener == nil {
+		var err error
+		if adminListener, err = listenAdmin(adminServer.Addr); err != nil {
+			proxyListener.Close()
+			return err
+		}
+	}
+
+	lc.started = true
+	lc.proxyServer, lc.adminServer = proxyServer, adminServer
+	lc.proxyListener, lc.adminListener = proxyListener, adminListener
+	lc.tunnels = tunnels
+	lc.serveErrs = make(chan error, 2)
+	lc.stopped = make(chan struct{})
+
+	fields := logrus.Fields{
+		"Port":                    p.GetPort(),
+		"Address":                 proxyListener.Addr().String(),
+		"Number of sites blocked": p.GetList().Length(),
+		"Number of sites allowed": p.GetAllowList().Length(),
+		"Mode":                    p.GetMode(),
+	}
+	if logger, ok := p.GetLogger().(*logrus.Logger); ok {
+		fields["Log Level"] = logger.GetLevel().String()
+	}
+	p.GetLogger().WithFields(fields).Info("Procrastiproxy running...")
+
+	if !p.AdminAuth.Enabled() {
+		p.GetLogger().WithFields(logrus.Fields{
+			"Admin Address": p.GetAdminAddress(),
+		}).Warn("The admin endpoints are unauthenticated. Set an admin token or password to protect them")
+	}
+
+	go lc.serve(proxyServer, proxyListener)
+	if adminServer != nil {
+		if p.adminListener == nil && !adminAddressIsLocal(adminServer.Addr) && !p.AdminAuth.Enabled() {
+			p.GetLogger().WithFields(logrus.Fields{
+				"Admin Address": adminServer.Addr,
+			}).Warn("The unauthenticated admin endpoints are reachable from other machines")
+		}
+		p.GetLogger().WithFields(logrus.Fields{
+			"Admin Address": adminListener.Addr().String(),
+		}).Info("Serving admin endpoints")
+		go lc.serve(adminServer, adminListener)
+	}
+
+	stopped := lc.stopped
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+		p.GetLogger().Info("Shutting down procrastiproxy...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		defer cancel()
+		p.Shutdown(shutdownCtx)
+	}()
+
+	return nil
+}
+
+func (lc *lifecycle) serve(server *http.Server, listener net.Listener) {
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		lc.serveErrs <- err
+	}
+}
+
+// Addr returns the address the proxy is listening on, or nil if it hasn't been started. This is useful when the proxy
+// was asked to listen on port 0, so that the system picked a free port
+func (p *Procrastiproxy) Addr() net.Addr {
+	p.lifecycle.mu.Lock()
+	defer p.lifecycle.mu.Unlock()
+	if p.lifecycle.proxyListener == nil {
+		return nil
+	}
+	return p.lifecycle.proxyListener.Addr()
+}
+
+// AdminAddr returns the address the admin endpoints are listening on, or nil if the proxy hasn't been started or they
+// share the proxy's listener
+func (p *Procrastiproxy) AdminAddr() net.Addr {
+	p.lifecycle.mu.Lock()
+	defer p.lifecycle.mu.Unlock()
+	if p.lifecycle.adminListener == nil {
+		return nil
+	}
+	return p.lifecycle.adminListener.Addr()
+}
+
+// Wait blocks until a proxy started with Start has shut down, returning the error that caused it to stop, if any.
+// Should a server fail while serving, the proxy is shut down and the failure returned
+func (p *Procrastiproxy) Wait() error {
+	lc := &p.lifecycle
+	lc.mu.Lock()
+	started, serveErrs, stopped := lc.started, lc.serveErrs, lc.stopped
+	lc.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	select {
+	case err := <-serveErrs:
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		defer cancel()
+		p.Shutdown(shutdownCtx)
+		return err
+	case <-stopped:
+		return lc.shutdownErr
+	}
+}
+
+// Shutdown gracefully stops the proxy. It stops accepting connections, waits for requests and tunnels that are underway
+// to finish, and then saves the runtime state of the block list. Should ctx be done first, the remaining connections
+// and tunnels are closed and ctx's error is returned. Calling Shutdown again returns the result of the first call
+func (p *Procrastiproxy) Shutdown(ctx context.Context) error {
+	lc := &p.lifecycle
+	lc.shutdownOnce.Do(func() {
+		lc.mu.Lock()
+		proxyServer, adminServer, tunnels, stopped := lc.proxyServer, lc.adminServer, lc.tunnels, lc.stopped
+		lc.mu.Unlock()
+
+		var result *multierror.Error
+		var deadlineErr error
+
+		for _, server := range []*http.Server{proxyServer, adminServer} {
+			if server == nil {
+				continue
+			}
+			if err := server.Shutdown(ctx); err != nil {
+				// The deadline passed, so stop waiting on the connections that remain
+				server.Close()
+				deadlineErr = err
+			}
+		}
+
+		if tunnels != nil {
+			if closed, err := tunnels.drain(ctx); err != nil {
+				p.GetLogger().WithFields(logrus.Fields{
+					"Tunnels": closed,
+				}).Warn("Closed tunnels that were still open when the shutdown deadline passed")
+				deadlineErr = err
+			}
+		}
+
+		if err := p.saveState(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if closer, ok := p.Store.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+
+		if deadlineErr != nil {
+			result = multierror.Append(result, deadlineErr)
+		}
+		lc.shutdownErr = result.ErrorOrNil()
+
+		if stopped != nil {
+			close(stopped)
+		}
+		p.GetLogger().Info("Procrastiproxy stopped")
+	})
+	return lc.shutdownErr
+}
+
+// saveState saves the runtime changes to the block list to the proxy's Store, if it has one
+func (p *Procrastiproxy) saveState() error {
+	if p.Store == nil {
+		return nil
+	}
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.configMu.RLock()
+	s := p.state.copy()
+	p.configMu.RUnlock()
+	return p.Store.Save(s)
+}
+
+// tunnelTracker keeps track of the connections of open CONNECT tunnels, so that shutdown can wait for them to finish
+type tunnelTracker struct {
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+	closing bool
+}
+
+type tunnelTrackerKey struct{}
+
+func newTunnelTracker() *tunnelTracker {
+	return &tunnelTracker{conns: make(map[net.Conn]struct{})}
+}
+
+func withTunnelTracker(ctx context.Context, t *tunnelTracker) context.Context {
+	return context.WithValue(ctx, tunnelTrackerKey{}, t)
+}
+
+// tunnelTrackerFrom returns the tracker for tunnels opened by requests with ctx, or nil if they aren't tracked
+func tunnelTrackerFrom(ctx context.Context) *tunnelTracker {
+	t, _ := ctx.Value(tunnelTrackerKey{}).(*tunnelTracker)
+	return t
+}
+
+// add registers a tunnel's connections, reporting false if the tracker is draining and the tunnel should not be opened
+func (t *tunnelTracker) add(conns ...net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+	for _, c := range conns {
+		t.conns[c] = struct{}{}
+	}
+	t.wg.Add(1)
+	return true
+}
+
+// done unregisters a tunnel's connections once it has finished
+func (t *tunnelTracker) done(conns ...net.Conn) {
+	t.mu.Lock()
+	for _, c := range conns {
+		delete(t.conns, c)
+	}
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// drain refuses new tunnels and waits for those that are open to finish. If ctx is done first, the remaining tunnels
+// are closed, and their number is returned along with ctx's error
+func (t *tunnelTracker) drain(ctx context.Context) (int, error) {
+	t.mu.Lock()
+	t.closing = true
+	t.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return 0, nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	// Each tunnel has two connections
+	closed := len(t.conns) / 2
+	for c := range t.conns {
+		c.Close()
+	}
+	t.mu.Unlock()
+	<-finished
+	return closed, ctx.Err()
+}
@@ -0,0 +1,196 @@
+package procrastiproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLifecycleProxy returns a proxy that listens on free loopback ports and never blocks anything
+func newTestLifecycleProxy() *Procrastiproxy {
+	p := NewProcrastiproxy()
+	p.ProxyAddress = "127.0.0.1:0"
+	p.SetAdminAddress("127.0.0.1:0")
+	return p
+}
+
+// newEchoUpstream starts a TCP server that echoes back whatever it receives, returning its address
+func newEchoUpstream(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// openTunnel opens a CONNECT tunnel through the proxy to authority, checking that bytes flow through it
+func openTunnel(t *testing.T, proxyAddr net.Addr, authority string) net.Conn {
+	conn, err := net.Dial("tcp", proxyAddr.String())
+	require.NoError(t, err)
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", authority, authority)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	echoed := make([]byte, 4)
+	_, err = io.ReadFull(reader, echoed)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(echoed))
+	return conn
+}
+
+func TestStartServesUntilContextDone(t *testing.T) {
+	_, testURL := newTestUpstream(t)
+	p := newTestLifecycleProxy()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, p.Start(ctx))
+
+	proxyURL, err := url.Parse("http://" + p.Addr().String())
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(testURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf("http://%s/api/v1/status", p.AdminAddr()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	require.NoError(t, p.Wait())
+
+	_, err = net.Dial("tcp", p.Addr().String())
+	require.Error(t, err)
+}
+
+func TestStartErrors(t *testing.T) {
+	p := newTestLifecycleProxy()
+	require.NoError(t, p.Start(context.Background()))
+	defer p.Shutdown(context.Background())
+
+	require.Equal(t, ServerAlreadyStartedError{}, p.Start(context.Background()))
+
+	// The address is already in use by the first proxy
+	other := NewProcrastiproxy()
+	other.ProxyAddress = p.Addr().String()
+	require.Error(t, other.Start(context.Background()))
+}
+
+func TestShutdownWaitsForTunnels(t *testing.T) {
+	p := newTestLifecycleProxy()
+	require.NoError(t, p.Start(context.Background()))
+	conn := openTunnel(t, p.Addr(), newEchoUpstream(t))
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- p.Shutdown(ctx)
+	}()
+
+	// Shutdown waits while the tunnel is still in use
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a tunnel was open")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	conn.Close()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return once the tunnel closed")
+	}
+}
+
+func TestShutdownClosesTunnelsAtDeadline(t *testing.T) {
+	p := newTestLifecycleProxy()
+	require.NoError(t, p.Start(context.Background()))
+	conn := openTunnel(t, p.Addr(), newEchoUpstream(t))
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := p.Shutdown(ctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// The tunnel was closed from the proxy's side
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, readErr := conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, readErr)
+
+	// Later calls report the same result
+	require.Equal(t, err, p.Shutdown(context.Background()))
+	require.Equal(t, err, p.Wait())
+}
+
+// recordingStore is a Store that remembers what was saved, and whether it was closed
+type recordingStore struct {
+	m      sync.Mutex
+	saved  []State
+	closed bool
+}
+
+func (s *recordingStore) Load() (State, error) {
+	return State{}, nil
+}
+
+func (s *recordingStore) Save(state State) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.saved = append(s.saved, state)
+	return nil
+}
+
+func (s *recordingStore) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestShutdownSavesState(t *testing.T) {
+	store := &recordingStore{}
+	p := newTestLifecycleProxy()
+	p.Store = store
+	require.NoError(t, p.Start(context.Background()))
+	require.NoError(t, p.Block("reddit.com"))
+
+	require.NoError(t, p.Shutdown(context.Background()))
+
+	store.m.Lock()
+	defer store.m.Unlock()
+	require.True(t, store.closed)
+	require.Equal(t, State{Blocked: []string{"reddit.com"}}, store.saved[len(store.saved)-1])
+}
@@ -0,0 +1,281 @@
+package procrastiproxy
+
+// openAPIDocument describes the JSON admin API. It is served at /api/v1/openapi.json
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "procrastiproxy admin API",
+    "description": "Manage procrastiproxy's block list, allow list and schedule while it is running.",
+    "version": "1.0.0"
+  },
+  "servers": [
+    {"url": "/api/v1"}
+  ],
+  "paths": {
+    "/blocklist": {
+      "get": {
+        "summary": "List the blocked hosts",
+        "operationId": "listBlockList",
+        "responses": {
+          "200": {
+            "description": "The blocked hosts, in alphabetical order",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockList"}}}
+          }
+        }
+      },
+      "post": {
+        "summary": "Add a host to the block list",
+        "operationId": "addToBlockList",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockListEntry"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The host was added to the block list",
+            "headers": {"Location": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockListEntry"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "409": {"$ref": "#/components/responses/Conflict"},
+          "500": {"$ref": "#/components/responses/InternalServerError"}
+        }
+      }
+    },
+    "/blocklist/{host}": {
+      "delete": {
+        "summary": "Remove a host from the block list",
+        "operationId": "removeFromBlockList",
+        "parameters": [
+          {"name": "host", "in": "path", "required": true, "schema": {"type": "string"}, "example": "reddit.com"}
+        ],
+        "responses": {
+          "204": {"description": "The host was removed from the block list"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "500": {"$ref": "#/components/responses/InternalServerError"}
+        }
+      }
+    },
+    "/blocklist/import": {
+      "post": {
+        "summary": "Add every host listed in a file to the block list",
+        "description": "The file may list one host per line, or be in hosts file (0.0.0.0 reddit.com) or Adblock domain rule (||reddit.com^) format. Lines starting with # or ! are comments. Nothing is imported if any line is invalid, and each invalid line is reported with its line number.",
+        "operationId": "importToBlockList",
+        "requestBody": {
+          "required": true,
+          "content": {"text/plain": {"schema": {"type": "string"}, "example": "0.0.0.0 reddit.com\n||youtube.com^\nnews.ycombinator.com\n"}}
+        },
+        "responses": {
+          "200": {
+            "description": "The hosts were added to the block list",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ImportResult"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "500": {"$ref": "#/components/responses/InternalServerError"}
+        }
+      }
+    },
+    "/allowlist": {
+      "get": {
+        "summary": "List the hosts permitted during block windows",
+        "operationId": "listAllowList",
+        "responses": {
+          "200": {
+            "description": "The allowed hosts, in alphabetical order",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockList"}}}
+          }
+        }
+      },
+      "post": {
+        "summary": "Add a host to the allow list",
+        "operationId": "addToAllowList",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockListEntry"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The host was added to the allow list",
+            "headers": {"Location": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockListEntry"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "409": {"$ref": "#/components/responses/Conflict"},
+          "500": {"$ref": "#/components/responses/InternalServerError"}
+        }
+      }
+    },
+    "/allowlist/{host}": {
+      "delete": {
+        "summary": "Remove a host from the allow list",
+        "operationId": "removeFromAllowList",
+        "parameters": [
+          {"name": "host", "in": "path", "required": true, "schema": {"type": "string"}, "example": "docs.github.com"}
+        ],
+        "responses": {
+          "204": {"description": "The host was removed from the allow list"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "500": {"$ref": "#/components/responses/InternalServerError"}
+        }
+      }
+    },
+    "/allowlist/import": {
+      "post": {
+        "summary": "Add every host listed in a file to the allow list",
+        "description": "The file may list one host per line, or be in hosts file (0.0.0.0 reddit.com) or Adblock domain rule (||reddit.com^) format. Lines starting with # or ! are comments. Nothing is imported if any line is invalid, and each invalid line is reported with its line number.",
+        "operationId": "importToAllowList",
+        "requestBody": {
+          "required": true,
+          "content": {"text/plain": {"schema": {"type": "string"}, "example": "0.0.0.0 reddit.com\n||youtube.com^\nnews.ycombinator.com\n"}}
+        },
+        "responses": {
+          "200": {
+            "description": "The hosts were added to the allow list",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ImportResult"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "500": {"$ref": "#/components/responses/InternalServerError"}
+        }
+      }
+    },
+    "/schedule": {
+      "get": {
+        "summary": "Show when the block list applies",
+        "operationId": "getSchedule",
+        "responses": {
+          "200": {
+            "description": "The current schedule",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Schedule"}}}
+          }
+        }
+      },
+      "put": {
+        "summary": "Replace when the block list applies, until the config file is next loaded",
+        "operationId": "putSchedule",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Schedule"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The schedule now in effect",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Schedule"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"}
+        }
+      }
+    },
+    "/status": {
+      "get": {
+        "summary": "Show whether the block list currently applies",
+        "operationId": "getStatus",
+        "responses": {
+          "200": {
+            "description": "The proxy's status",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}
+          }
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "operationId": "getOpenAPIDocument",
+        "responses": {
+          "200": {"description": "The OpenAPI document for the admin API", "content": {"application/json": {}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "BlockList": {
+        "type": "object",
+        "required": ["hosts"],
+        "properties": {
+          "hosts": {"type": "array", "items": {"type": "string"}, "example": ["*.youtube.com", "reddit.com"]}
+        }
+      },
+      "BlockListEntry": {
+        "type": "object",
+        "required": ["host"],
+        "properties": {
+          "host": {
+            "type": "string",
+            "description": "A domain, which also covers its subdomains, a wildcard domain, a host and port, or an IP address or network in CIDR notation",
+            "example": "reddit.com"
+          }
+        }
+      },
+      "ImportResult": {
+        "type": "object",
+        "required": ["imported", "added"],
+        "properties": {
+          "imported": {"type": "integer", "description": "The number of distinct hosts the file listed", "example": 120},
+          "added": {"type": "integer", "description": "The number of those hosts that weren't already on the list", "example": 95}
+        }
+      },
+      "Schedule": {
+        "type": "object",
+        "properties": {
+          "timezone": {"type": "string", "description": "IANA time zone. Empty for the system's local time zone", "example": "America/New_York"},
+          "block_start_time": {"type": "string", "example": "9:00AM"},
+          "block_end_time": {"type": "string", "example": "5:00PM"},
+          "block_windows": {
+            "type": "array",
+            "description": "Windows that apply every day, overriding the start and end times",
+            "items": {"type": "string"},
+            "example": ["9:00AM-12:00PM", "1:00PM-5:00PM"]
+          },
+          "schedule": {
+            "type": "array",
+            "description": "Per-weekday windows, applied in order, overriding the start and end times and the block windows",
+            "items": {"type": "string"},
+            "example": ["mon-fri=9:00AM-5:00PM", "sat=10:00AM-12:00PM", "sun=off"]
+          }
+        }
+      },
+      "Status": {
+        "type": "object",
+        "required": ["time", "in_block_window", "blocked_hosts", "allowed_hosts", "mode"],
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "in_block_window": {"type": "boolean"},
+          "window": {"type": "string", "description": "The block window now falls within", "example": "9:00AM-5:00PM"},
+          "blocked_hosts": {"type": "integer"},
+          "allowed_hosts": {"type": "integer"},
+          "mode": {"type": "string", "enum": ["deny", "allow"], "description": "Whether the block list or the allow list decides which hosts are refused"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {"type": "string"},
+          "problems": {"type": "array", "items": {"type": "string"}}
+        }
+      }
+    },
+    "responses": {
+      "BadRequest": {
+        "description": "The request was malformed or invalid",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "NotFound": {
+        "description": "The host is not on the list",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Conflict": {
+        "description": "The host is already on the list",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "InternalServerError": {
+        "description": "The change was applied, but could not be saved",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,206 @@
+package procrastiproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Option configures a Procrastiproxy created by NewProcrastiproxy
+type Option func(*Procrastiproxy)
+
+// WithList sets the block list, which is empty by default
+func WithList(list *List) Option {
+	return func(p *Procrastiproxy) {
+		p.List = list
+	}
+}
+
+// WithAllowList sets the allow list, which is empty by default. A nil list is treated as an empty one
+func WithAllowList(list *List) Option {
+	return func(p *Procrastiproxy) {
+		if list == nil {
+			list = NewList()
+		}
+		p.AllowList = list
+	}
+}
+
+// WithMode sets whether the block list or the allow list decides which hosts are refused. It is ModeDeny by default
+func WithMode(mode Mode) Option {
+	return func(p *Procrastiproxy) {
+		p.Mode = mode
+	}
+}
+
+// WithURLRules sets rules that block or permit requests by their URL. Create them with ParseURLRule
+func WithURLRules(rules ...URLRule) Option {
+	return func(p *Procrastiproxy) {
+		p.URLRules = rules
+	}
+}
+
+// WithBlockTimes sets the start and end of the window, in the time.Kitchen format, during which the block list applies
+// every day. They are 9:00AM and 5:00PM by default. Invalid times are logged and ignored; call
+// ConfigureProxyTimeSettings instead to handle the error
+func WithBlockTimes(start, end string) Option {
+	return func(p *Procrastiproxy) {
+		p.warnInvalidOption("block times", p.ConfigureProxyTimeSettings(start, end))
+	}
+}
+
+// WithBlockWindows sets several windows during which the block list applies every day, overriding the block times.
+// Invalid windows are logged and ignored; call ConfigureBlockWindows instead to handle the error
+func WithBlockWindows(windows []BlockWindow) Option {
+	return func(p *Procrastiproxy) {
+		p.warnInvalidOption("block windows", p.ConfigureBlockWindows(windows))
+	}
+}
+
+// WithSchedule sets a per-weekday schedule of block windows, overriding the block times and windows. Invalid windows
+// are logged and ignored; call ConfigureSchedule instead to handle the error
+func WithSchedule(schedule WeeklySchedule) Option {
+	return func(p *Procrastiproxy) {
+		p.warnInvalidOption("schedule", p.ConfigureSchedule(schedule))
+	}
+}
+
+// WithClock sets the clock the proxy reads the current time from, which is the system clock by default. Pass a
+// FakeClock to control time in tests
+func WithClock(clock Clock) Option {
+	return func(p *Procrastiproxy) {
+		p.clock = clock
+	}
+}
+
+// WithLocation sets the time zone in which block windows are evaluated. By default, they are evaluated in the location
+// of the time being checked, which is the system's local time zone
+func WithLocation(location *time.Location) Option {
+	return func(p *Procrastiproxy) {
+		p.warnInvalidOption("location", p.changeTimeSettings(func(pts *ProxyTimeSettings) {
+			pts.Timezone = location.String()
+			pts.location = location
+		}))
+	}
+}
+
+// WithPort sets the port the proxy listens on, on every interface
+func WithPort(port string) Option {
+	return func(p *Procrastiproxy) {
+		p.SetPort(port)
+	}
+}
+
+// WithProxyAddress sets the address the proxy listens on, overriding the port
+func WithProxyAddress(address string) Option {
+	return func(p *Procrastiproxy) {
+		p.ProxyAddress = address
+	}
+}
+
+// WithAdminAddress sets the address the admin endpoints are served on. By default, they share the proxy's listener
+func WithAdminAddress(address string) Option {
+	return func(p *Procrastiproxy) {
+		p.SetAdminAddress(address)
+	}
+}
+
+// WithListener makes the proxy serve on an existing listener, rather than listening on its address
+func WithListener(listener net.Listener) Option {
+	return func(p *Procrastiproxy) {
+		p.listener = listener
+	}
+}
+
+// WithAdminListener serves the admin endpoints on an existing listener, rather than listening on the admin address
+func WithAdminListener(listener net.Listener) Option {
+	return func(p *Procrastiproxy) {
+		p.adminListener = listener
+	}
+}
+
+// WithAdminAuth sets the credentials the admin endpoints require
+func WithAdminAuth(auth AdminAuth) Option {
+	return func(p *Procrastiproxy) {
+		p.AdminAuth = auth
+	}
+}
+
+// WithStore persists changes made to the block list through the admin endpoints to store
+func WithStore(store Store) Option {
+	return func(p *Procrastiproxy) {
+		p.Store = store
+	}
+}
+
+// WithTransport sets the transport that plain HTTP requests are forwarded with, which is http.DefaultTransport by
+// default. Redirects are returned to the caller, rather than followed, whatever the transport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(p *Procrastiproxy) {
+		p.transport = transport
+	}
+}
+
+// WithDialContext sets the function used to connect to the hosts that CONNECT tunnels lead to
+func WithDialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) Option {
+	return func(p *Procrastiproxy) {
+		p.dialContext = dial
+	}
+}
+
+// WithLogger sets the logger, which is logrus' standard logger by default
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(p *Procrastiproxy) {
+		p.logger = logger
+	}
+}
+
+// WithBlockPage sets the handler that responds to blocked requests in place of the default 403 Forbidden
+func WithBlockPage(page http.Handler) Option {
+	return func(p *Procrastiproxy) {
+		p.blockPage = page
+	}
+}
+
+// GetLogger returns the logger the proxy writes to
+func (p *Procrastiproxy) GetLogger() logrus.FieldLogger {
+	if p.logger == nil {
+		return logrus.StandardLogger()
+	}
+	return p.logger
+}
+
+func (p *Procrastiproxy) getClock() Clock {
+	if p.clock == nil {
+		return SystemClock
+	}
+	return p.clock
+}
+
+func (p *Procrastiproxy) getTransport() http.RoundTripper {
+	if p.transport == nil {
+		return http.DefaultTransport
+	}
+	return p.transport
+}
+
+func (p *Procrastiproxy) getDialContext() func(ctx context.Context, network, address string) (net.Conn, error) {
+	if p.dialContext == nil {
+		return (&net.Dialer{}).DialContext
+	}
+	return p.dialContext
+}
+
+// warnInvalidOption logs an option that couldn't be applied, as options have no way to return an error
+func (p *Procrastiproxy) warnInvalidOption(option string, err error) {
+	if err == nil {
+		return
+	}
+	p.GetLogger().WithFields(logrus.Fields{
+		"Option": option,
+		"Error":  err,
+	}).Warn("Ignoring invalid option")
+}
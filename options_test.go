@@ -0,0 +1,130 @@
+package procrastiproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripperFunc adapts a function into an http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewProcrastiproxyOptions(t *testing.T) {
+	clock := NewFakeClock(time.Date(2022, time.June, 6, 22, 30, 0, 0, time.UTC))
+	list := NewList()
+	list.Add("reddit.com")
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	logger, _ := test.NewNullLogger()
+
+	p := NewProcrastiproxy(
+		WithClock(clock),
+		WithList(list),
+		WithBlockTimes("6:00PM", "11:00PM"),
+		WithLocation(newYork),
+		WithPort("9000"),
+		WithAdminAddress("127.0.0.1:9001"),
+		WithAdminAuth(AdminAuth{Token: "s3cret"}),
+		WithLogger(logger),
+	)
+
+	require.Equal(t, clock.Now(), p.Now())
+	require.Equal(t, list, p.GetList())
+	require.Equal(t, "9000", p.GetPort())
+	require.Equal(t, "127.0.0.1:9001", p.GetAdminAddress())
+	require.Equal(t, "s3cret", p.AdminAuth.Token)
+	require.Equal(t, "America/New_York", p.GetProxyTimeSettings().Timezone)
+	require.Equal(t, logger, p.GetLogger())
+
+	// 10:30PM UTC is 6:30PM in New York, within the block window
+	require.True(t, p.WithinBlockWindow(p.Now()))
+
+	// Options don't leak into other instances
+	other := NewProcrastiproxy()
+	require.Equal(t, 0, other.GetList().Length())
+	require.Equal(t, "", other.GetProxyTimeSettings().Timezone)
+}
+
+func TestWithTransportAndBlockPage(t *testing.T) {
+	var forwarded []string
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		forwarded = append(forwarded, r.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("from the transport")),
+		}, nil
+	})
+	blockPage := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Get back to work"))
+	})
+
+	p := NewProcrastiproxy(
+		WithClock(NewFakeClock(time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC))),
+		WithTransport(transport),
+		WithBlockPage(blockPage),
+	)
+	p.GetList().Add("reddit.com")
+
+	w := httptest.NewRecorder()
+	p.timeAwareHandler(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "from the transport", w.Body.String())
+	require.Equal(t, []string{"http://example.com/"}, forwarded)
+
+	w = httptest.NewRecorder()
+	p.timeAwareHandler(w, httptest.NewRequest(http.MethodGet, "http://reddit.com/", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, "Get back to work", w.Body.String())
+}
+
+func TestWithListenersAndDialContext(t *testing.T) {
+	echo := newEchoUpstream(t)
+	var dialed []string
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		// Send every tunnel to the echo server, whatever it asked for
+		return (&net.Dialer{}).DialContext(ctx, network, echo)
+	}
+
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	adminListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	p := NewProcrastiproxy(
+		WithListener(proxyListener),
+		WithAdminListener(adminListener),
+		WithDialContext(dial),
+	)
+	require.NoError(t, p.Start(context.Background()))
+	defer p.Shutdown(context.Background())
+
+	require.Equal(t, proxyListener.Addr(), p.Addr())
+	require.Equal(t, adminListener.Addr(), p.AdminAddr())
+
+	conn := openTunnel(t, p.Addr(), "tunnel.invalid:443")
+	conn.Close()
+	require.Equal(t, []string{"tunnel.invalid:443"}, dialed)
+
+	resp, err := http.Get((&url.URL{Scheme: "http", Host: adminListener.Addr().String(), Path: "/api/v1/status"}).String())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
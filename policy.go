@@ -0,0 +1,153 @@
+package procrastiproxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Decision describes whether a request was blocked, and why
+type Decision struct {
+	// Host is the sanitized host the request is destined for
+	Host string
+	// InBlockWindow reports whether the request was made during a block window that applies to Host
+	InBlockWindow bool
+	// Window is the block window the request was made during, when InBlockWindow is set
+	Window BlockWindow
+	// Blocked reports whether the request is refused, rather than forwarded
+	Blocked bool
+	// Reason explains the decision, e.g., "host on block list during block window"
+	Reason string
+	// Rule identifies the rule that matched the request, e.g., "block list: reddit.com". It is empty when no rule
+	// matched, and the request is forwarded because nothing said otherwise
+	Rule string
+}
+
+// Matched reports whether a rule matched the request, rather than the decision being the default of forwarding it
+func (d Decision) Matched() bool {
+	return d.Rule != ""
+}
+
+// Policy decides whether requests are blocked. A Policy that has no opinion about a request returns a Decision without a
+// Rule, leaving the decision to the policies after it in a Chain
+type Policy interface {
+	Decide(ctx context.Context, r *http.Request, now time.Time) Decision
+}
+
+// PolicyFunc adapts a function into a Policy
+type PolicyFunc func(ctx context.Context, r *http.Request, now time.Time) Decision
+
+func (f PolicyFunc) Decide(ctx context.Context, r *http.Request, now time.Time) Decision {
+	return f(ctx, r, now)
+}
+
+// Chain returns a Policy that consults each of policies in turn, returning the first Decision that matched a rule. If
+// none did, the last policy's Decision is returned, so that a chain ending in DefaultPolicy reports its block window
+func Chain(policies ...Policy) Policy {
+	return PolicyFunc(func(ctx context.Context, r *http.Request, now time.Time) Decision {
+		d := Decision{Host: requestHost(r)}
+		for _, policy := range policies {
+			d = policy.Decide(ctx, r, now)
+			if d.Matched() {
+				return d
+			}
+		}
+		return d
+	})
+}
+
+// WithPolicy layers custom policies, such as allow lists or quotas, over the proxy's block list and schedule. They are
+// consulted in order before DefaultPolicy, and the first to match a rule decides the request
+func WithPolicy(policies ...Policy) Option {
+	return func(p *Procrastiproxy) {
+		// Copy first, so that appending the default policy never writes into the caller's backing array
+		p.policy = Chain(append(append([]Policy(nil), policies...), p.DefaultPolicy())...)
+	}
+}
+
+// DefaultPolicy returns the proxy's built-in policy. During the block windows that apply to a host, it applies the first
+// URL rule matching the request. Failing that, it permits hosts on the allow list, and then, depending on the Mode,
+// refuses hosts on the block list or every other host
+func (p *Procrastiproxy) DefaultPolicy() Policy {
+	return defaultPolicy{p: p}
+}
+
+func (p *Procrastiproxy) getPolicy() Policy {
+	if p.policy == nil {
+		return p.DefaultPolicy()
+	}
+	return p.policy
+}
+
+type defaultPolicy struct {
+	p *Procrastiproxy
+}
+
+func (dp defaultPolicy) Decide(ctx context.Context, r *http.Request, now time.Time) Decision {
+	p := dp.p
+	d := p.windowDecision(r, requestHost(r), now)
+	if d.Blocked {
+		d.Reason += " during block window"
+	}
+	return d
+}
+
+// windowDecision decides r according to the block windows and lists
+func (p *Procrastiproxy) windowDecision(r *http.Request, host string, now time.Time) Decision {
+	// Decide against a single snapshot of the configuration, but don't hold it while forwarding, which may take a while
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+
+	window, inWindow := p.MatchHostBlockWindow(host, now)
+	if !inWindow {
+		return Decision{Host: host, Reason: "outside block window"}
+	}
+	return p.listDecision(r, Decision{Host: host, InBlockWindow: true, Window: window})
+}
+
+// listDecision completes d, which describes r, according to the allow list, the block list, the mode and then the URL
+// rules. The caller must hold configMu
+func (p *Procrastiproxy) listDecision(r *http.Request, d Decision) Decision {
+	d = p.hostDecision(d)
+
+	// URL rules are more specific than the host lists, so the first rule matching the URL overrides them
+	if rule, ok := matchURLRules(p.URLRules, r); ok {
+		d.Blocked = !rule.Allow
+		d.Reason = "URL matches block rule"
+		if rule.Allow {
+			d.Reason = "URL matches allow rule"
+		}
+		d.Rule = "url rule: " + rule.String()
+	}
+	return d
+}
+
+// hostDecision completes d according to the allow list, the block list and the mode. The caller must hold configMu
+func (p *Procrastiproxy) hostDecision(d Decision) Decision {
+	// A host on the allow list is always permitted, even when a block list entry also covers it, so that
+	// docs.reddit.com can be carved out of a block on reddit.com. A nil allow list is treated as an empty one
+	if allowList := p.GetAllowList(); allowList != nil {
+		if entry, ok := allowList.Match(d.Host); ok {
+			d.Reason = "host on allow list"
+			d.Rule = "allow list: " + entry
+			return d
+		}
+	}
+
+	if p.GetMode() == ModeAllow {
+		d.Blocked = true
+		d.Reason = "host not on allow list"
+		d.Rule = "allow mode"
+		return d
+	}
+
+	entry, ok := p.GetList().Match(d.Host)
+	if !ok {
+		d.Reason = "host not on block list"
+		return d
+	}
+	d.Blocked = true
+	d.Reason = "host on block list"
+	d.Rule = "block list: " + entry
+	return d
+}
@@ -0,0 +1,139 @@
+package procrastiproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Target string
+		Now    time.Time
+		Want   Decision
+	}{
+		{
+			Name:   "Blocked host during block window",
+			Target: "http://www.reddit.com/r/golang",
+			Now:    time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC),
+			Want: Decision{
+				Host:          "www.reddit.com",
+				InBlockWindow: true,
+				Window:        BlockWindow{Start: "9:00AM", End: "5:00PM"},
+				Blocked:       true,
+				Reason:        "host on block list during block window",
+				Rule:          "block list: reddit.com",
+			},
+		},
+		{
+			Name:   "Permitted host during block window",
+			Target: "http://example.com/",
+			Now:    time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC),
+			Want: Decision{
+				Host:          "example.com",
+				InBlockWindow: true,
+				Window:        BlockWindow{Start: "9:00AM", End: "5:00PM"},
+				Reason:        "host not on block list",
+			},
+		},
+		{
+			Name:   "Blocked host outside block window",
+			Target: "http://reddit.com/",
+			Now:    time.Date(2022, time.June, 6, 20, 0, 0, 0, time.UTC),
+			Want: Decision{
+				Host:   "reddit.com",
+				Reason: "outside block window",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			p.GetList().Add("reddit.com")
+
+			r := httptest.NewRequest(http.MethodGet, tc.Target, nil)
+			d := p.DefaultPolicy().Decide(context.Background(), r, tc.Now)
+			require.Equal(t, tc.Want, d)
+			require.Equal(t, tc.Want.Blocked, d.Matched())
+		})
+	}
+}
+
+// allowListPolicy permits requests to hosts ending in one of its suffixes, and has no opinion about the rest
+type allowListPolicy []string
+
+func (a allowListPolicy) Decide(ctx context.Context, r *http.Request, now time.Time) Decision {
+	host := requestHost(r)
+	for _, suffix := range a {
+		if strings.HasSuffix(host, suffix) {
+			return Decision{Host: host, Reason: "host on allow list", Rule: "allow list: " + suffix}
+		}
+	}
+	return Decision{Host: host}
+}
+
+func TestChain(t *testing.T) {
+	blockEverything := PolicyFunc(func(ctx context.Context, r *http.Request, now time.Time) Decision {
+		return Decision{Host: requestHost(r), Blocked: true, Reason: "everything is blocked", Rule: "block everything"}
+	})
+	policy := Chain(allowListPolicy{"docs.reddit.com"}, blockEverything)
+
+	d := policy.Decide(context.Background(), httptest.NewRequest(http.MethodGet, "http://docs.reddit.com/", nil), time.Now())
+	require.False(t, d.Blocked)
+	require.Equal(t, "allow list: docs.reddit.com", d.Rule)
+
+	d = policy.Decide(context.Background(), httptest.NewRequest(http.MethodGet, "http://reddit.com/", nil), time.Now())
+	require.True(t, d.Blocked)
+	require.Equal(t, "block everything", d.Rule)
+
+	// When no policy matches, the request is forwarded
+	d = Chain().Decide(context.Background(), httptest.NewRequest(http.MethodGet, "http://reddit.com/", nil), time.Now())
+	require.Equal(t, Decision{Host: "reddit.com"}, d)
+}
+
+func TestWithPolicyLayersOverDefault(t *testing.T) {
+	testHost, testURL := newTestUpstream(t)
+	p := NewProcrastiproxy(
+		WithClock(NewFakeClock(time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC))),
+		WithPolicy(allowListPolicy{testHost}),
+	)
+	AddHostToBlockList(p.GetList(), testHost, "reddit.com")
+
+	var decisions []Decision
+	WithAfterDecision(func(r *http.Request, d Decision) {
+		decisions = append(decisions, d)
+	})(p)
+
+	// The allow list takes precedence over the block list
+	w := httptest.NewRecorder()
+	p.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, testURL, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Requests the allow list has no opinion about fall through to the block list
+	w = httptest.NewRecorder()
+	p.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://reddit.com/", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	require.Len(t, decisions, 2)
+	require.Equal(t, "allow list: "+testHost, decisions[0].Rule)
+	require.Equal(t, "block list: reddit.com", decisions[1].Rule)
+	require.Equal(t, "host on block list during block window", decisions[1].Reason)
+}
+
+// TestWithPolicyCopiesPolicies ensures that WithPolicy does not write the default policy into spare capacity of the
+// caller's slice, where it would clobber whatever the caller keeps there
+func TestWithPolicyCopiesPolicies(t *testing.T) {
+	sentinel := allowListPolicy{"sentinel.example.com"}
+	policies := make([]Policy, 1, 2)
+	policies[0] = allowListPolicy{"docs.reddit.com"}
+	spare := append(policies, sentinel)
+
+	NewProcrastiproxy(WithPolicy(policies...))
+	require.Equal(t, sentinel, spare[1])
+}
@@ -1,16 +1,22 @@
 package procrastiproxy
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	// Embed the IANA time zone database so --timezone works on hosts without one installed
+	_ "time/tzdata"
 
 	"github.com/hashicorp/go-multierror"
 
@@ -18,19 +24,63 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// DefaultNow is the default implementation of Procrastiproxy's Now function,
-// as we want procrastiproxy to return the actual time during normal operations. In
-// testing, we override this method with static values (e.g., 9:00PM or 3:23 AM) in test
-// cases to simulate different wall-times for verifiying procrastiproxy's behavior
-var DefaultNow = time.Now
-
-var logLevel string
-
 type Procrastiproxy struct {
-	Now  func() time.Time
 	Port string
-	List *List
-	ProxyTimeSettings
+	// ProxyAddress, when set, is the address the proxy listens on, overriding Port, e.g., 127.0.0.1:8000
+	ProxyAddress string
+	// AdminAddress is where the admin endpoints are served. When empty, they are served on the proxy's listener
+	AdminAddress string
+	// AdminAuth holds the credentials the admin endpoints require. They are open when it is empty
+	AdminAuth AdminAuth
+	List      *List
+	// AllowList holds the hosts that are permitted during a block window, whatever the Mode
+	AllowList *List
+	// Mode selects whether the block list or the allow list decides which hosts are refused during a block window
+	Mode Mode
+	// URLRules block or permit requests by their URL, taking precedence over the block and allow lists. The first rule
+	// that matches a request applies
+	URLRules []URLRule
+	// ResolveHosts, when set, also refuses hostnames that resolve into a network on the block list
+	ResolveHosts bool
+
+	// timeSettings decide when the block list applies. They are only set through the Configure methods, Options and
+	// ApplyConfig, which compile them, so that requests only ever read them
+	timeSettings ProxyTimeSettings
+
+	// configMu is held for reading while a block decision is made, and for writing while a reload swaps the block list
+	// and time settings, so that every request is decided against a single, consistent configuration
+	configMu sync.RWMutex
+
+	// lifecycle tracks the servers started by Start
+	lifecycle lifecycle
+
+	// Store, when set, persists changes made to the block list through the admin endpoints
+	Store Store
+	// state holds the runtime changes to the block list. It is changed while holding both stateMu and configMu
+	state   State
+	stateMu sync.Mutex
+	// reloadMu serializes reloads of the config, which may be triggered by both file changes and signals
+	reloadMu sync.Mutex
+	// blockFiles are the block files the current config was read from, which are watched for changes
+	blockFiles []string
+
+	// The following are set through Options, and fall back to defaults when left unset
+	clock         Clock
+	transport     http.RoundTripper
+	dialContext   func(ctx context.Context, network, address string) (net.Conn, error)
+	logger        logrus.FieldLogger
+	listener      net.Listener
+	adminListener net.Listener
+	blockPage     http.Handler
+	beforeHooks   []Middleware
+	afterHooks    []AfterDecisionHook
+	policy        Policy
+	resolver      Resolver
+
+	// resolvedTransport forwards requests whose host was resolved and checked against the block list. It is built from
+	// transport on first use
+	resolvedTransport     http.RoundTripper
+	resolvedTransportOnce sync.Once
 }
 
 type AdminCommand struct {
@@ -39,8 +89,19 @@ type AdminCommand struct {
 }
 
 type List struct {
-	m       sync.Mutex
-	members map[string]bool
+	m sync.Mutex
+	// members maps each host to its own schedule, or to nil when the host follows the global block windows
+	members map[string]*listMember
+	// domains indexes the members without a port, so that subdomains can be matched against them
+	domains *hostTrie
+	// networks indexes the members that are IP addresses or networks, so that addresses can be matched against them
+	networks *ipTrie
+}
+
+// listMember is a host's own schedule, along with its parsed form
+type listMember struct {
+	schedule *HostSchedule
+	compiled *blockSchedule
 }
 
 type timeFlag struct {
@@ -48,9 +109,7 @@ type timeFlag struct {
 	Value string
 }
 
-var (
-	proxyTimeSettings ProxyTimeSettings
-
+const (
 	defaultBlockStartTime = "9:00AM"
 	defaultBlockEndTime   = "5:00PM"
 	defaultLayout         = "9:00AM"
@@ -61,13 +120,107 @@ type ProxyTimeSettings struct {
 	BlockStartTime string
 	BlockEndTime   string
 	DefaultLayout  string
+	// BlockWindows optionally lists several windows that apply every day, such as 9:00AM-12:00PM and 1:00PM-5:00PM.
+	// When empty, the single window formed by BlockStartTime and BlockEndTime applies
+	BlockWindows []BlockWindow
+	// Schedule optionally sets a different block window for each day of the week. When nil, the window formed by
+	// BlockStartTime and BlockEndTime applies every day
+	Schedule WeeklySchedule
+
+	// location is the loaded form of Timezone. When nil, times are evaluated in whatever location they are supplied in
+	location *time.Location
+	// schedule is the parsed form of the block times, windows and weekly schedule, which requests are matched against.
+	// It is rebuilt by compile whenever they change
+	schedule *blockSchedule
+}
+
+// compile parses the time zone, block times, windows and weekly schedule into the form requests are matched against,
+// so that parsing can't fail while handling a request. Empty block times stand for the defaults of 9:00AM and 5:00PM.
+// The settings are left unchanged when any of them are invalid
+func (pts *ProxyTimeSettings) compile() error {
+	start, end := pts.BlockStartTime, pts.BlockEndTime
+	if start == "" {
+		start = defaultBlockStartTime
+	}
+	if end == "" {
+		end = defaultBlockEndTime
+	}
+	// The block times are validated even when windows or a weekly schedule override them, as they apply again once
+	// those are removed
+	if err := parseStartAndEndTimes(start, end); err != nil {
+		return err
+	}
+	windows := pts.BlockWindows
+	if len(windows) == 0 {
+		windows = []BlockWindow{{Start: start, End: end}}
+	}
+	schedule, err := compileBlockSchedule(windows, pts.Schedule)
+	if err != nil {
+		return err
+	}
+
+	// Keep a location supplied directly, e.g., through WithLocation, as long as it is the one Timezone names
+	location := pts.location
+	if location == nil || location.String() != pts.Timezone {
+		if location, err = loadTimezone(pts.Timezone); err != nil {
+			return err
+		}
+	}
+
+	pts.location = location
+	pts.schedule = schedule
+	return nil
 }
 
-func NewProcrastiproxy() *Procrastiproxy {
-	return &Procrastiproxy{
-		Now:  DefaultNow,
-		List: NewList(),
+// copy returns the settings with their own copy of the windows, so that changing one doesn't change the other
+func (pts ProxyTimeSettings) copy() ProxyTimeSettings {
+	if pts.BlockWindows != nil {
+		pts.BlockWindows = append([]BlockWindow{}, pts.BlockWindows...)
 	}
+	if pts.Schedule != nil {
+		schedule := make(WeeklySchedule, len(pts.Schedule))
+		for day, windows := range pts.Schedule {
+			if windows != nil {
+				windows = append([]BlockWindow{}, windows...)
+			}
+			schedule[day] = windows
+		}
+		pts.Schedule = schedule
+	}
+	return pts
+}
+
+// defaultTimeSettings apply to a Procrastiproxy whose time settings were never configured, such as one that wasn't
+// created by NewProcrastiproxy: blocking between 9:00AM and 5:00PM
+var defaultTimeSettings = func() ProxyTimeSettings {
+	pts := ProxyTimeSettings{
+		BlockStartTime: defaultBlockStartTime,
+		BlockEndTime:   defaultBlockEndTime,
+		DefaultLayout:  defaultLayout,
+	}
+	pts.compile()
+	return pts
+}()
+
+// NewProcrastiproxy returns a Procrastiproxy that blocks nothing until hosts are added to its List, and which blocks
+// between 9:00AM and 5:00PM. Options override these and the other defaults, so that several independently configured
+// instances can run in one process
+func NewProcrastiproxy(opts ...Option) *Procrastiproxy {
+	p := &Procrastiproxy{
+		List:      NewList(),
+		AllowList: NewList(),
+		Mode:      ModeDeny,
+	}
+	p.timeSettings = defaultTimeSettings
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Now returns the current time, as read from the proxy's Clock. The system clock is used unless WithClock sets another
+func (p *Procrastiproxy) Now() time.Time {
+	return p.getClock().Now()
 }
 
 func (p *Procrastiproxy) GetList() *List {
@@ -82,6 +235,14 @@ func (p *Procrastiproxy) GetPort() string {
 	return p.Port
 }
 
+func (p *Procrastiproxy) SetAdminAddress(s string) {
+	p.AdminAddress = s
+}
+
+func (p *Procrastiproxy) GetAdminAddress() string {
+	return p.AdminAddress
+}
+
 // custom errors
 
 type EmptyBlockListError struct{}
@@ -100,77 +261,151 @@ func (err InvalidTimeFormatError) Error() string {
 	return fmt.Sprintf("Invalid time value {%s} passed with flag {%s}. Format must be time.Kitchen: e.g., 9:15AM. Parse error: %v", err.Value, err.FlagName, err.Underlying)
 }
 
+// UpstreamErrorKind categorizes the ways in which reaching an upstream host can fail
+type UpstreamErrorKind string
+
+const (
+	UpstreamDNSError               UpstreamErrorKind = "dns"
+	UpstreamConnectionRefusedError UpstreamErrorKind = "connection refused"
+	UpstreamTimeoutError           UpstreamErrorKind = "timeout"
+	UpstreamTLSError               UpstreamErrorKind = "tls"
+	UpstreamUnknownError           UpstreamErrorKind = "unknown"
+)
+
+// UpstreamError is returned when procrastiproxy cannot complete a request to the upstream host on the caller's behalf
+type UpstreamError struct {
+	Host       string
+	Kind       UpstreamErrorKind
+	Underlying error
+}
+
+func (err UpstreamError) Error() string {
+	return fmt.Sprintf("Failed to reach upstream host {%s} (%s): %v", err.Host, err.Kind, err.Underlying)
+}
+
+func (err UpstreamError) Unwrap() error {
+	return err.Underlying
+}
+
+// StatusCode returns the gateway status code that best describes the failure to the caller
+func (err UpstreamError) StatusCode() int {
+	if err.Kind == UpstreamTimeoutError {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+type ZeroLengthBlockWindowError struct {
+	Start string
+	End   string
+}
+
+func (err ZeroLengthBlockWindowError) Error() string {
+	return fmt.Sprintf("Block window start {%s} and end {%s} are the same time, so nothing would ever be blocked. To block overnight, supply an end time earlier than the start time: e.g., 10:00PM to 6:00AM", err.Start, err.End)
+}
+
+type InvalidTimezoneError struct {
+	Value      string
+	Underlying error
+}
+
+func (err InvalidTimezoneError) Error() string {
+	return fmt.Sprintf("Invalid time zone {%s} passed with flag {timezone}. Value must be an IANA time zone name: e.g., America/New_York. Load error: %v", err.Value, err.Underlying)
+}
+
 // RunCLI is the main entrypoint for the procrastiproxy package
 func RunCLI() error {
 
-	port := flag.String("port", "8000", "Port to listen on. Defaults to 8000")
-	logLevel := flag.String("loglevel", "info", "Log level. Defaults to Info")
-	blockList := flag.String("block", "", "Host to block. Defaults to none")
-	blockStartTime := flag.String("block-start-time", defaultBlockStartTime, "Start of business hours. Defaults to 9:00AM")
-	blockEndTime := flag.String("block-end-time", defaultBlockEndTime, "End of business hours. Defaults to 5:00PM")
+	flags := registerFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	level, err := log.ParseLevel(*logLevel)
+	cfg, configPath, err := flags.resolveConfig(flag.CommandLine)
 	if err != nil {
-		level = log.DebugLevel
+		return err
 	}
-	log.SetLevel(level)
 
 	p := NewProcrastiproxy()
 
-	if parseErr := parseBlockListInput(blockList, p.GetList()); parseErr != nil {
-		return parseErr
+	if applyErr := p.ApplyConfig(cfg); applyErr != nil {
+		return InvalidConfigError{Path: configPath, Underlying: applyErr}
 	}
 
-	if parseErr := parseStartAndEndTimes(*blockStartTime, *blockEndTime); parseErr != nil {
-		return parseErr
+	if cfg.StateFile != "" {
+		p.Store = NewFileStore(cfg.StateFile)
+	}
+	// Re-apply changes made through the admin endpoints before we start accepting requests
+	if stateErr := p.LoadState(); stateErr != nil {
+		return stateErr
 	}
 
-	// Configure proxy time-based block settings
-	p.ConfigureProxyTimeSettings(*blockStartTime, *blockEndTime)
+	level, _ := log.ParseLevel(cfg.LogLevel)
+	log.SetLevel(level)
 
-	if *port == "" {
-		return errors.New("You must supply a valid port via the --port flag")
-	}
-	if *blockList == "" {
-		log.Debug("Proxy will allow all traffic, because you did not supply any sites to block via the --block flag")
-	}
+	// Shut down gracefully on SIGINT or SIGTERM, letting requests and tunnels that are underway finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	p.SetPort(*port)
+	if configPath != "" {
+		log.WithFields(logrus.Fields{
+			"Path": configPath,
+		}).Debug("Loaded config file")
+	}
 
-	RunServer(p)
+	// Pick up edits to the config file and block files, and reload them on demand when sent SIGHUP. Without a config
+	// file, a reload reads the block files passed with --block-file again
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	load := func() (Config, error) {
+		return flags.loadConfig(flag.CommandLine, configPath)
+	}
+	go p.reloadOnSignal(configPath, load, signals, ctx.Done())
+	if configPath != "" || len(cfg.BlockFiles) > 0 {
+		go func() {
+			if watchErr := p.watchConfig(configPath, load, ctx.Done()); watchErr != nil {
+				log.WithFields(logrus.Fields{
+					"Path":  configPath,
+					"Error": watchErr,
+				}).Warn("Unable to watch config and block files for changes. Send SIGHUP to reload them instead")
+			}
+		}()
+	}
 
-	return nil
+	return serveUntilDone(ctx, p)
 }
 
-func makeProxyRequest(w http.ResponseWriter, r *http.Request) {
-	// Perform the supplied request and return the response to caller
-	res, err := http.Get(r.URL.String())
-	if err != nil {
-		log.Fatal(err)
+// forwardRequest sends a permitted request on to its destination, tunneling CONNECT requests and
+// proxying everything else. Any error returned has already been reported to the caller where possible
+func (p *Procrastiproxy) forwardRequest(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodConnect {
+		return p.tunnelRequest(w, r)
 	}
-	body, err := ioutil.ReadAll(res.Body)
-	// Return the request to caller by performing a very rudimentary clone of it, here
-	w.Write(body)
+	return p.makeProxyRequest(w, r)
 }
 
-func blockRequest(w http.ResponseWriter) {
+// blockRequest refuses a request, with the block page if one was configured
+func (p *Procrastiproxy) blockRequest(w http.ResponseWriter, r *http.Request) {
+	if p.blockPage != nil {
+		p.blockPage.ServeHTTP(w, r)
+		return
+	}
 	w.WriteHeader(http.StatusForbidden)
 	w.Write([]byte("Forbidden"))
 }
 
 func (p *Procrastiproxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
-	log.WithFields(logrus.Fields{
+	p.GetLogger().WithFields(logrus.Fields{
 		"Host": r.URL.Host,
 		"Path": r.URL.Path,
 	}).Debug("Proxy handler received request")
 
-	log.WithFields(logrus.Fields{
+	p.GetLogger().WithFields(logrus.Fields{
 		"blocked sites": p.GetList().All(),
 	}).Debug("Blocked site hosts")
 
-	makeProxyRequest(w, r)
+	if err := p.forwardRequest(w, r); err != nil {
+		p.logUpstreamError(r, err)
+	}
 }
 
 func parseCommandFromPath(path string) (*AdminCommand, error) {
@@ -183,7 +418,6 @@ func parseCommandFromPath(path string) (*AdminCommand, error) {
 		aCmd.Command = pathElem[2]
 	}
 	url, parseErr := url.Parse(pathElem[3])
-	log.Debugf("Parsed URL: %s\n", url.String())
 	if parseErr != nil {
 		return aCmd, parseErr
 	}
@@ -192,53 +426,125 @@ func parseCommandFromPath(path string) (*AdminCommand, error) {
 }
 
 func (p *Procrastiproxy) timeAwareHandler(w http.ResponseWriter, r *http.Request) {
-	if p.WithinBlockWindow(p.Now()) {
-		log.Debug("Request made within block time window. Examining if host permitted..")
-		p.blockListAwareHandler(w, r)
-		return
+	d := p.getPolicy().Decide(r.Context(), r, p.Now())
+
+	if d.InBlockWindow {
+		p.GetLogger().WithFields(logrus.Fields{
+			"Window": d.Window.String(),
+		}).Debug("Request made within block time window")
+	} else {
+		p.GetLogger().Debug("Request made outside of configured block time window")
 	}
-	log.Debug("Request made outside of configured block time window. Passing through...")
-	p.proxyHandler(w, r)
+
+	// Hostnames that no rule decided during a block window may still resolve into a blocked network
+	p.configMu.RLock()
+	resolve := d.InBlockWindow && p.shouldResolve(d)
+	p.configMu.RUnlock()
+	p.blockOrForward(w, r, d, resolve)
+}
+
+// requestHost returns the sanitized host that a proxied request is destined for
+func requestHost(r *http.Request) string {
+	if r.Method == http.MethodConnect {
+		// Check the CONNECT authority before the tunnel is opened, as we can't see inside it afterwards
+		return connectAuthority(r)
+	}
+	return sanitizeHost(r.URL.Host)
 }
 
 func (p *Procrastiproxy) blockListAwareHandler(w http.ResponseWriter, r *http.Request) {
-	host := sanitizeHost(r.URL.Host)
-	if hostIsOnBlockList(host, p.GetList()) {
-		log.Debugf("Blocking request to host: %s. User explicitly blocked and present time is within configured proxy block window", host)
-		blockRequest(w)
+	host := requestHost(r)
+
+	p.configMu.RLock()
+	d := p.listDecision(r, Decision{Host: host})
+	resolve := p.shouldResolve(d)
+	p.configMu.RUnlock()
+
+	p.blockOrForward(w, r, d, resolve)
+}
+
+// blockOrForward carries out a decision about a request: refusing it if blocked, or else forwarding it. When resolve is
+// set, the request's host is first resolved and its addresses checked against the block list, which may refuse it
+func (p *Procrastiproxy) blockOrForward(w http.ResponseWriter, r *http.Request, d Decision, resolve bool) {
+	if resolve {
+		var err error
+		r, d, err = p.resolveRequest(r, d)
+		if err != nil {
+			p.afterDecision(r, d)
+			upstreamErr := newUpstreamError(d.Host, err)
+			writeUpstreamError(w, upstreamErr)
+			p.logUpstreamError(r, upstreamErr)
+			return
+		}
+	}
+	p.afterDecision(r, d)
+
+	if d.Blocked {
+		p.GetLogger().WithFields(logrus.Fields{
+			"Host":   d.Host,
+			"Reason": d.Reason,
+			"Rule":   d.Rule,
+		}).Debug("Blocking request")
+		p.blockRequest(w, r)
 		return
 	}
-	makeProxyRequest(w, r)
+	p.proxyHandler(w, r)
 }
 
 func (p *Procrastiproxy) adminHandler(w http.ResponseWriter, r *http.Request) {
-	log.WithFields(logrus.Fields{
+	p.GetLogger().WithFields(logrus.Fields{
 		"Path": r.URL.Path,
 	}).Debug("Admin handler received request")
 
 	adminCmd, err := parseCommandFromPath(r.URL.Path)
 	if err != nil {
-		log.Println(err)
+		p.GetLogger().Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if adminCmd.Command == "" || adminCmd.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Unknown admin command in path: %s. Use /admin/block/<host> or /admin/unblock/<host>\n", r.URL.Path)))
+		return
 	}
 
 	var respMsg string
-	list := p.GetList()
 
 	if adminCmd.Command == "block" {
-		list.Add(adminCmd.Host)
+		if err := p.Block(adminCmd.Host); err != nil {
+			p.adminStoreError(w, adminCmd, err)
+			return
+		}
 		respMsg = fmt.Sprintf("Successfully added: %s to the block list\n", adminCmd.Host)
 	}
 	if adminCmd.Command == "unblock" {
-		list.Remove(adminCmd.Host)
+		if err := p.Unblock(adminCmd.Host); err != nil {
+			p.adminStoreError(w, adminCmd, err)
+			return
+		}
 		respMsg = fmt.Sprintf("Successfully removed: %s from the block list\n", adminCmd.Host)
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(respMsg))
 }
 
+// adminStoreError reports that an admin command was applied, but could not be persisted
+func (p *Procrastiproxy) adminStoreError(w http.ResponseWriter, adminCmd *AdminCommand, err error) {
+	p.GetLogger().WithFields(logrus.Fields{
+		"Command": adminCmd.Command,
+		"Host":    adminCmd.Host,
+		"Error":   err,
+	}).Error("Failed to persist block list change")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(fmt.Sprintf("Applied %s of %s, but failed to save it, so it will be lost on restart: %v\n", adminCmd.Command, adminCmd.Host, err)))
+}
+
 func NewList() *List {
 	return &List{
-		members: make(map[string]bool),
+		members:  make(map[string]*listMember),
+		domains:  newHostTrie(),
+		networks: newIPTrie(),
 	}
 }
 
@@ -246,7 +552,9 @@ func NewList() *List {
 func (l *List) Clear() {
 	defer l.m.Unlock()
 	l.m.Lock()
-	l.members = make(map[string]bool)
+	l.members = make(map[string]*listMember)
+	l.domains = newHostTrie()
+	l.networks = newIPTrie()
 }
 
 // All returns every member of the list
@@ -260,25 +568,150 @@ func (l *List) All() []string {
 	return members
 }
 
-// Add appends an item to the list
+// Add appends an item to the list. Items may be a domain, which also covers its subdomains (reddit.com), a wildcard
+// domain covering only subdomains (*.reddit.com), a host and port, which is matched exactly (localhost:8080), or an IP
+// address or network in CIDR notation (151.101.0.0/16, 2a04:4e42::/32), which covers every address within it.
+// Adding an item that is already present leaves its schedule unchanged
 func (l *List) Add(item string) {
+	item = listKey(item)
+	l.m.Lock()
+	defer l.m.Unlock()
+	if _, ok := l.members[item]; !ok {
+		l.add(item, nil)
+	}
+}
+
+// AddWithSchedule appends an item to the list that is blocked according to its own schedule, rather than the global
+// block windows. A nil schedule makes the item follow the global block windows. The item is not added if its schedule
+// contains invalid times
+func (l *List) AddWithSchedule(item string, schedule *HostSchedule) error {
+	var member *listMember
+	if schedule != nil {
+		windows, windowsErr := normalizeBlockWindows(schedule.BlockWindows)
+		weekly, weeklyErr := normalizeSchedule(schedule.Schedule)
+		if err := multierror.Append(windowsErr, weeklyErr).ErrorOrNil(); err != nil {
+			return InvalidHostScheduleError{Value: item, Reason: err.Error()}
+		}
+		schedule = &HostSchedule{BlockWindows: windows, Schedule: weekly}
+		compiled, err := compileBlockSchedule(schedule.BlockWindows, schedule.Schedule)
+		if err != nil {
+			return InvalidHostScheduleError{Value: item, Reason: err.Error()}
+		}
+		member = &listMember{schedule: schedule, compiled: compiled}
+	}
+
+	item = listKey(item)
 	l.m.Lock()
 	defer l.m.Unlock()
-	l.members[item] = true
+	l.add(item, member)
+	return nil
+}
+
+// add records an item returned by listKey. Callers must hold the lock
+func (l *List) add(item string, member *listMember) {
+	l.members[item] = member
+	if _, network, ok := parseNetwork(item); ok {
+		l.networks.insert(network, item)
+	} else if !hasPort(item) {
+		l.domains.insert(item)
+	}
+}
+
+// listKey returns the form in which item is stored in a List: sanitized, and with IP addresses and networks in
+// canonical form, so that 151.101.7.7/16 and 151.101.0.0/16 are the same member
+func listKey(item string) string {
+	item = sanitizeHost(item)
+	if canonical, _, ok := parseNetwork(item); ok {
+		return canonical
+	}
+	return item
+}
+
+// Schedule returns the item's own schedule, or nil if the item follows the global block windows or is not a member
+func (l *List) Schedule(item string) *HostSchedule {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if member := l.members[listKey(item)]; member != nil {
+		return member.schedule
+	}
+	return nil
+}
+
+// compiledSchedule returns the parsed form of the item's own schedule, or nil if it follows the global block windows
+func (l *List) compiledSchedule(item string) *blockSchedule {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if member := l.members[listKey(item)]; member != nil {
+		return member.compiled
+	}
+	return nil
 }
 
 // Remove deletes an item from the list
 func (l *List) Remove(item string) {
+	item = listKey(item)
 	l.m.Lock()
 	defer l.m.Unlock()
 	delete(l.members, item)
+	if _, network, ok := parseNetwork(item); ok {
+		l.networks.remove(network)
+	} else if !hasPort(item) {
+		l.domains.remove(item)
+	}
 }
 
-// Contains returns true if the supplied item is a member of the list
+// Contains returns true if the supplied item is a member of the list. Unlike Match, subdomains of a member are not
+// themselves members
 func (l *List) Contains(item string) bool {
 	l.m.Lock()
 	defer l.m.Unlock()
-	return l.members[item]
+	_, ok := l.members[listKey(item)]
+	return ok
+}
+
+// Match returns the member of the list that covers host, if any. A member with a port must match host exactly, while
+// otherwise host's port is ignored and the most specific member covering host or one of its parent domains is returned:
+// old.reddit.com:443 matches reddit.com. An IP address is matched against the most specific network containing it:
+// 151.101.1.69 matches 151.101.0.0/16
+func (l *List) Match(host string) (string, bool) {
+	host = sanitizeHost(host)
+	l.m.Lock()
+	defer l.m.Unlock()
+	if _, ok := l.members[host]; ok {
+		return host, true
+	}
+	if ip := net.ParseIP(stripPort(host)); ip != nil {
+		return l.networks.match(ip)
+	}
+	return l.domains.match(stripPort(host))
+}
+
+// MatchIP returns the member of the list that is the most specific network containing ip, if any
+func (l *List) MatchIP(ip net.IP) (string, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.networks.match(ip)
+}
+
+// hasNetworks reports whether any member of the list is an IP address or network
+func (l *List) hasNetworks() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.networks.size > 0
+}
+
+// Replace swaps the contents of the list for those of other in a single step, so that concurrent lookups see either
+// the old contents or the new, but never a mixture. other must not be used afterwards
+func (l *List) Replace(other *List) {
+	other.m.Lock()
+	members, domains, networks := other.members, other.domains, other.networks
+	other.m.Unlock()
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.members = members
+	l.domains = domains
+	l.networks = networks
 }
 
 // Length returns the number of members in the list
@@ -288,92 +721,174 @@ func (l *List) Length() int {
 	return len(l.members)
 }
 
-func (p *Procrastiproxy) ConfigureProxyTimeSettings(bts, bet string) {
-
-	pts := ProxyTimeSettings{}
-	if bts != "" {
-		pts.BlockStartTime = bts
-	} else {
-		pts.BlockStartTime = defaultBlockStartTime
+// ConfigureProxyTimeSettings sets the start and end of the window, in the time.Kitchen format, during which the block
+// list applies every day. Empty times revert to the defaults of 9:00AM and 5:00PM. Invalid times are reported, leaving
+// the settings unchanged
+func (p *Procrastiproxy) ConfigureProxyTimeSettings(bts, bet string) error {
+	if bts == "" {
+		bts = defaultBlockStartTime
 	}
-	if bet != "" {
-		pts.BlockEndTime = bet
-	} else {
-		pts.BlockEndTime = defaultBlockEndTime
+	if bet == "" {
+		bet = defaultBlockEndTime
 	}
-	pts.DefaultLayout = defaultLayout
-
-	p.ProxyTimeSettings = pts
+	// Preserve any previously configured time zone, windows and weekly schedule
+	return p.changeTimeSettings(func(pts *ProxyTimeSettings) {
+		pts.BlockStartTime = bts
+		pts.BlockEndTime = bet
+	})
 }
 
-func (p *Procrastiproxy) GetProxyTimeSettings() ProxyTimeSettings {
-	if p.ProxyTimeSettings == (ProxyTimeSettings{}) {
-		// we haven't configured the settings and set the variable yet
-		p.ConfigureProxyTimeSettings(defaultBlockStartTime, defaultBlockEndTime)
-		return p.ProxyTimeSettings
+// ConfigureBlockWindows sets several block windows that apply every day, which take precedence over the block start
+// and end times. Passing nil reverts to the block start and end times. Invalid windows are reported, leaving the
+// settings unchanged
+func (p *Procrastiproxy) ConfigureBlockWindows(windows []BlockWindow) error {
+	windows, err := normalizeBlockWindows(windows)
+	if err != nil {
+		return err
 	}
-	return p.ProxyTimeSettings
+	return p.changeTimeSettings(func(pts *ProxyTimeSettings) {
+		pts.BlockWindows = windows
+	})
 }
 
-// stringToTime accepts a string representation of a timestamp and attempts to convert it to
-// a time in the "Kitchen" format, e.g., 3:04PM
-func stringToTime(str string) time.Time {
-	tm, err := time.Parse(time.Kitchen, str)
+// ConfigureSchedule sets a per-weekday schedule of block windows, which takes precedence over the block start and end
+// times. Passing nil reverts to applying the block start and end times every day. Invalid windows are reported, leaving
+// the settings unchanged
+func (p *Procrastiproxy) ConfigureSchedule(schedule WeeklySchedule) error {
+	schedule, err := normalizeSchedule(schedule)
 	if err != nil {
-		log.Debugf("Failed to decode time: %s - error: %v\n", str, err)
+		return err
 	}
-	return tm
+	return p.changeTimeSettings(func(pts *ProxyTimeSettings) {
+		pts.Schedule = schedule
+	})
 }
 
-func (p *Procrastiproxy) WithinBlockWindow(now time.Time) bool {
-
-	pts := p.GetProxyTimeSettings()
+// ConfigureTimezone sets the IANA time zone (e.g., America/New_York) in which block windows are evaluated, so
+// that a proxy running on a server in another zone still blocks during the user's local hours. Passing an empty
+// string evaluates block windows in the location of the time being checked
+func (p *Procrastiproxy) ConfigureTimezone(tz string) error {
+	return p.changeTimeSettings(func(pts *ProxyTimeSettings) {
+		pts.Timezone = tz
+		pts.location = nil
+	})
+}
 
-	startTimeString := pts.BlockStartTime
-	endTimeString := pts.BlockEndTime
+// changeTimeSettings applies change to a copy of the time settings and compiles the result, which replaces the
+// settings if it is valid. It holds configMu, so that requests see either the old settings or the new
+func (p *Procrastiproxy) changeTimeSettings(change func(pts *ProxyTimeSettings)) error {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
 
-	start := stringToTime(startTimeString)
-	end := stringToTime(endTimeString)
+	pts := p.getTimeSettings()
+	change(&pts)
+	pts.DefaultLayout = defaultLayout
+	if err := pts.compile(); err != nil {
+		return err
+	}
+	p.timeSettings = pts
+	return nil
+}
 
-	// Create an equivalent unix epoch timestamp, but use now's hour, minutes and seconds
-	checkTime := time.Date(int(0000), time.January, int(1), now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), time.UTC)
+// loadTimezone loads the named IANA time zone, returning a nil location for an empty name
+func loadTimezone(tz string) (*time.Location, error) {
+	if tz == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, InvalidTimezoneError{Value: tz, Underlying: err}
+	}
+	return loc, nil
+}
 
-	log.Debugf("startTime: %v endTime: %v currentTime: %v", start, end, checkTime)
+// GetProxyTimeSettings returns a copy of the time settings. Changing the copy has no effect: use the Configure methods
+// to change them
+func (p *Procrastiproxy) GetProxyTimeSettings() ProxyTimeSettings {
+	return p.getTimeSettings().copy()
+}
 
-	if checkTime.Before(start) {
-		return false
+// getTimeSettings returns the compiled time settings, which are the defaults until they are configured. The caller
+// must not change them, and, while requests are being served, must hold configMu
+func (p *Procrastiproxy) getTimeSettings() ProxyTimeSettings {
+	if p.timeSettings.schedule == nil {
+		return defaultTimeSettings
 	}
+	return p.timeSettings
+}
 
-	if checkTime.Before(end) {
-		return true
+// WithinBlockWindow reports whether now falls within any of the configured block windows
+func (p *Procrastiproxy) WithinBlockWindow(now time.Time) bool {
+	_, ok := p.MatchBlockWindow(now)
+	return ok
+}
+
+// MatchBlockWindow returns the block window that now falls within, reporting false if there is none
+func (p *Procrastiproxy) MatchBlockWindow(now time.Time) (BlockWindow, bool) {
+	return p.getTimeSettings().matchBlockWindow(now)
+}
+
+// MatchHostBlockWindow is like MatchBlockWindow, but evaluates the host's own schedule when the block list entry for
+// host carries one, falling back to the global block windows otherwise
+func (p *Procrastiproxy) MatchHostBlockWindow(host string, now time.Time) (BlockWindow, bool) {
+	pts := p.getTimeSettings()
+	// Subdomains follow the schedule of the list entry that covers them
+	if member, ok := p.GetList().Match(host); ok {
+		if hs := p.GetList().compiledSchedule(member); hs != nil {
+			pts.schedule = hs
+		}
 	}
+	return pts.matchBlockWindow(now)
+}
 
-	return false
+// matchBlockWindow returns the window of the compiled schedule that now falls within
+func (pts ProxyTimeSettings) matchBlockWindow(now time.Time) (BlockWindow, bool) {
+	// Evaluate the wall-clock time in the configured time zone. Converting the instant, rather than its wall-clock
+	// reading, keeps us correct across daylight saving time transitions
+	if pts.location != nil {
+		now = now.In(pts.location)
+	}
 
+	return pts.schedule.match(now)
 }
 
+// sanitizeHost trims and lower cases host, and then converts it into its canonical form with canonicalHost
 func sanitizeHost(host string) string {
-	return strings.ToLower(strings.TrimSpace(strings.Replace(host, "\n", "", -1)))
+	return canonicalHost(strings.ToLower(strings.TrimSpace(strings.Replace(host, "\n", "", -1))))
 }
 
-func hostIsOnBlockList(host string, list *List) bool {
-	host = sanitizeHost(host)
-	return list.Contains(host)
+// hasPort reports whether host ends with a port, as in reddit.com:443 or [::1]:8080
+func hasPort(host string) bool {
+	_, _, err := net.SplitHostPort(host)
+	return err == nil
 }
 
-func RunServer(p *Procrastiproxy) {
+// stripPort removes any port from host, along with the brackets around an IPv6 address
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
 
-	log.WithFields(logrus.Fields{
-		"Port":                    p.GetPort(),
-		"Address":                 fmt.Sprintf("http://127.0.0.1:%s", p.GetPort()),
-		"Number of sites blocked": p.GetList().Length(),
-		"Log Level":               log.GetLevel().String(),
-	}).Info("Procrastiproxy running...")
+func hostIsOnBlockList(host string, list *List) bool {
+	_, ok := list.Match(host)
+	return ok
+}
 
-	http.HandleFunc("/", p.timeAwareHandler)
-	http.HandleFunc("/admin/", p.adminHandler)
+// RunServer serves the proxy until it receives SIGINT or SIGTERM, and then shuts it down gracefully
+func RunServer(p *Procrastiproxy) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serveUntilDone(ctx, p)
+}
 
-	log.Fatal(http.ListenAndServe(":"+p.GetPort(), nil))
+// serveUntilDone serves the proxy until ctx is done, returning once it has shut down
+func serveUntilDone(ctx context.Context, p *Procrastiproxy) error {
+	if err := p.Start(ctx); err != nil {
+		return err
+	}
+	return p.Wait()
 }
 
 func parseStartAndEndTimes(blockTimeStart, blockTimeEnd string) error {
@@ -391,10 +906,19 @@ func parseStartAndEndTimes(blockTimeStart, blockTimeEnd string) error {
 		},
 	}
 
+	var parsed []time.Time
 	for _, timeFlag := range timeFlags {
-		if _, parseErr := time.Parse(time.Kitchen, timeFlag.Value); parseErr != nil {
+		tm, parseErr := time.Parse(time.Kitchen, timeFlag.Value)
+		if parseErr != nil {
 			result = multierror.Append(result, InvalidTimeFormatError{FlagName: timeFlag.Name, Value: timeFlag.Value, Underlying: parseErr})
+			continue
 		}
+		parsed = append(parsed, tm)
+	}
+
+	// An end time before the start time is a valid overnight window, but identical times block nothing
+	if len(parsed) == len(timeFlags) && parsed[0].Equal(parsed[1]) {
+		result = multierror.Append(result, ZeroLengthBlockWindowError{Start: blockTimeStart, End: blockTimeEnd})
 	}
 	return result.ErrorOrNil()
 }
@@ -433,12 +957,17 @@ func validateBlockListInput(blockListMembers []string) error {
 	return nil
 }
 
-func parseBlockListInput(blockList *string, list *List) error {
+// splitBlockList converts the comma-separated value of the --block flag into its hosts
+func splitBlockList(blockListString string) []string {
 	var blockListMembers []string
-	var blockListString = *blockList
 	if blockListString != "" {
 		blockListMembers = strings.Split(blockListString, ",")
 	}
+	return blockListMembers
+}
+
+func parseBlockListInput(blockList *string, list *List) error {
+	blockListMembers := splitBlockList(*blockList)
 
 	validationErr := validateBlockListInput(blockListMembers)
 	if validationErr != nil {
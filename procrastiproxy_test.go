@@ -13,6 +13,23 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// newTestUpstream starts a local server standing in for a real website, so that tests don't depend upon
+// network access. It returns the server's host (addressed as localhost, so it can be passed through the
+// admin endpoints) along with its full URL
+func newTestUpstream(t *testing.T) (string, string) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "OK")
+	}))
+	t.Cleanup(ts.Close)
+
+	u, parseErr := url.Parse(ts.URL)
+	require.NoError(t, parseErr)
+
+	host := fmt.Sprintf("localhost:%s", u.Port())
+	return host, fmt.Sprintf("http://%s", host)
+}
+
 func TestRunCLIWithoutRequiredInputsErrors(t *testing.T) {
 	err := RunCLI()
 	require.Error(t, err)
@@ -50,17 +67,25 @@ func TestInvalidTimeFlagsRejected(t *testing.T) {
 			BlockTimeEnd:   "6:00PM",
 			Want:           nil,
 		},
+		{
+			Name:           "Overnight start and end times accepted",
+			BlockTimeStart: "10:00PM",
+			BlockTimeEnd:   "6:00AM",
+			Want:           nil,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 
 			err := parseStartAndEndTimes(tc.BlockTimeStart, tc.BlockTimeEnd)
 
-			if tc.Want == nil && err == nil {
+			if tc.Want == nil {
+				require.NoError(t, err)
 				return
 			}
 
-			if !errors.As(err, &tc.Want) {
+			var invalidTimeErr InvalidTimeFormatError
+			if !errors.As(err, &invalidTimeErr) {
 				t.Logf("%s - wanted error of type %T but got %T", tc.Name, tc.Want, err)
 				t.Fail()
 			}
@@ -69,6 +94,14 @@ func TestInvalidTimeFlagsRejected(t *testing.T) {
 
 }
 
+func TestZeroLengthBlockWindowRejected(t *testing.T) {
+	err := parseStartAndEndTimes("9:00AM", "9:00AM")
+	require.Error(t, err)
+
+	var zeroLengthErr ZeroLengthBlockWindowError
+	require.True(t, errors.As(err, &zeroLengthErr))
+}
+
 func TestGetList(t *testing.T) {
 	p := NewProcrastiproxy()
 
@@ -199,6 +232,107 @@ func TestListRemoveResultsInExpectedElements(t *testing.T) {
 	}
 }
 
+func TestListAddPreservesHostSchedule(t *testing.T) {
+	l := NewList()
+
+	schedule := &HostSchedule{BlockWindows: []BlockWindow{{Start: "9:00AM", End: "12:00PM"}}}
+	l.AddWithSchedule("youtube.com", schedule)
+	l.Add("youtube.com")
+	l.Add("reddit.com")
+
+	require.Equal(t, schedule, l.Schedule("youtube.com"))
+	require.Nil(t, l.Schedule("reddit.com"))
+	require.Nil(t, l.Schedule("not-a-member.com"))
+	require.Equal(t, 2, l.Length())
+}
+
+func TestListMatch(t *testing.T) {
+	l := NewList()
+	AddHostToBlockList(l, "reddit.com", "*.youtube.com", "localhost:8080", " Twitter.com")
+
+	testCases := []struct {
+		Name      string
+		Host      string
+		WantEntry string
+		WantMatch bool
+	}{
+		{Name: "Exact domain", Host: "reddit.com", WantEntry: "reddit.com", WantMatch: true},
+		{Name: "Port is ignored", Host: "reddit.com:80", WantEntry: "reddit.com", WantMatch: true},
+		{Name: "Subdomain is implied", Host: "old.reddit.com", WantEntry: "reddit.com", WantMatch: true},
+		{Name: "Subdomain with port", Host: "www.reddit.com:443", WantEntry: "reddit.com", WantMatch: true},
+		{Name: "Case is ignored", Host: "WWW.Reddit.COM", WantEntry: "reddit.com", WantMatch: true},
+		{Name: "Entries are sanitized", Host: "twitter.com", WantEntry: "twitter.com", WantMatch: true},
+		{Name: "Wildcard covers subdomains", Host: "www.youtube.com:443", WantEntry: "*.youtube.com", WantMatch: true},
+		{Name: "Wildcard excludes its parent", Host: "youtube.com", WantMatch: false},
+		{Name: "Entry with a port matches that port", Host: "localhost:8080", WantEntry: "localhost:8080", WantMatch: true},
+		{Name: "Entry with a port ignores other ports", Host: "localhost:9090", WantMatch: false},
+		{Name: "Lookalike domain is not matched", Host: "myreddit.com", WantMatch: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			entry, ok := l.Match(tc.Host)
+			require.Equal(t, tc.WantMatch, ok)
+			require.Equal(t, tc.WantEntry, entry)
+		})
+	}
+
+	// Removing the parent domain unblocks its subdomains
+	l.Remove("reddit.com")
+	_, ok := l.Match("old.reddit.com")
+	require.False(t, ok)
+}
+
+func TestListMatchNetworks(t *testing.T) {
+	l := NewList()
+	AddHostToBlockList(l, "151.101.7.7/16", "2A04:4E42::/32", "10.0.0.1", "reddit.com")
+
+	// Networks are stored in canonical form, whichever form they were added in
+	require.True(t, l.Contains("151.101.0.0/16"))
+	require.True(t, l.Contains("151.101.7.7/16"))
+	require.True(t, l.Contains("2a04:4e42::/32"))
+
+	testCases := []struct {
+		Name      string
+		Host      string
+		WantEntry string
+		WantMatch bool
+	}{
+		{Name: "Address within a network", Host: "151.101.1.69", WantEntry: "151.101.0.0/16", WantMatch: true},
+		{Name: "Port is ignored", Host: "151.101.1.69:443", WantEntry: "151.101.0.0/16", WantMatch: true},
+		{Name: "Address outside every network", Host: "151.102.1.69", WantMatch: false},
+		{Name: "Single address", Host: "10.0.0.1", WantEntry: "10.0.0.1", WantMatch: true},
+		{Name: "Neighbouring address", Host: "10.0.0.2", WantMatch: false},
+		{Name: "IPv6 address within a network", Host: "[2a04:4e42:200::396]:443", WantEntry: "2a04:4e42::/32", WantMatch: true},
+		{Name: "Domains are still matched by name", Host: "old.reddit.com", WantEntry: "reddit.com", WantMatch: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			entry, ok := l.Match(tc.Host)
+			require.Equal(t, tc.WantMatch, ok)
+			require.Equal(t, tc.WantEntry, entry)
+		})
+	}
+
+	l.Remove("151.101.0.0/16")
+	_, ok := l.Match("151.101.1.69")
+	require.False(t, ok)
+}
+
+// TestSubdomainBlocking ensures that blocking a domain blocks requests to its subdomains, on any port
+func TestSubdomainBlocking(t *testing.T) {
+	p := NewProcrastiproxy()
+	AddHostToBlockList(p.GetList(), "reddit.com")
+
+	for _, target := range []string{"http://reddit.com:80/r/golang", "http://old.reddit.com", "http://www.reddit.com/"} {
+		r := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+
+		http.HandlerFunc(p.blockListAwareHandler).ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusForbidden, w.Code, "expected %s to be blocked", target)
+	}
+}
+
 // TestHostBlocking ensures that you can access a host via the block list aware handler before it is added to the block list,
 // but not after it is added to the block list
 func TestHostBlocking(t *testing.T) {
@@ -351,10 +485,9 @@ func TestTimeAwareHandler(t *testing.T) {
 
 			p := NewProcrastiproxy()
 
-			p.Now = tc.Now
+			WithClock(NewFakeClock(tc.Now()))(p)
 
-			testHost := "example.com"
-			testHostURL := fmt.Sprintf("http://%s", testHost)
+			testHost, testHostURL := newTestUpstream(t)
 
 			AddHostToBlockList(p.GetList(), testHost)
 
@@ -386,10 +519,9 @@ func TestAdminHandlerBlocksHostsDynamically(t *testing.T) {
 	// Sanity check the initial block list is empty
 	require.Equal(t, p.GetList().Length(), 0)
 
-	testHost := "docker.com"
+	testHost, testHostURL := newTestUpstream(t)
 
 	// Sanity check that we can initially reach the target host because it has not yet been blocked
-	testHostURL := "http://docker.com"
 	fr := httptest.NewRequest("GET", testHostURL, strings.NewReader(""))
 	rw := httptest.NewRecorder()
 
@@ -436,8 +568,7 @@ func TestAdminHandlerUnblocksHostsDynamically(t *testing.T) {
 
 	p := NewProcrastiproxy()
 
-	testHost := "wikipedia.com"
-	testHostURL := fmt.Sprintf("http://%s", testHost)
+	testHost, testHostURL := newTestUpstream(t)
 
 	// Pre-populate the list with the test host
 	p.GetList().Add(testHost)
@@ -703,3 +834,127 @@ func TestParseStartAndEndTimes(t *testing.T) {
 		})
 	}
 }
+
+// TestWithinBlockWindowHonorsTimezone ensures block windows are evaluated in the configured time zone, regardless of
+// the location the current time is reported in, including across a daylight saving time transition
+func TestWithinBlockWindowHonorsTimezone(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Timezone string
+		Now      time.Time
+		Want     bool
+	}{
+		{
+			Name:     "8:30AM EST is before the window, despite being 1:30PM UTC",
+			Timezone: "America/New_York",
+			Now:      time.Date(2022, time.March, 10, 13, 30, 0, 0, time.UTC),
+			Want:     false,
+		},
+		{
+			Name:     "9:30AM EDT is within the window after DST begins, at the same UTC wall-clock time",
+			Timezone: "America/New_York",
+			Now:      time.Date(2022, time.March, 14, 13, 30, 0, 0, time.UTC),
+			Want:     true,
+		},
+		{
+			Name:     "4:59PM PDT is within the window",
+			Timezone: "America/Los_Angeles",
+			Now:      time.Date(2022, time.July, 1, 23, 59, 0, 0, time.UTC),
+			Want:     true,
+		},
+		{
+			Name:     "5:00PM PDT is outside the window",
+			Timezone: "America/Los_Angeles",
+			Now:      time.Date(2022, time.July, 2, 0, 0, 0, 0, time.UTC),
+			Want:     false,
+		},
+		{
+			Name:     "Without a time zone, the supplied wall-clock time is used",
+			Timezone: "",
+			Now:      time.Date(2022, time.July, 1, 13, 30, 0, 0, time.UTC),
+			Want:     true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			require.NoError(t, p.ConfigureTimezone(tc.Timezone))
+			p.ConfigureProxyTimeSettings("9:00AM", "5:00PM")
+
+			require.Equal(t, tc.Want, p.WithinBlockWindow(tc.Now))
+		})
+	}
+}
+
+func TestConfigureTimezoneRejectsInvalidNames(t *testing.T) {
+	p := NewProcrastiproxy()
+
+	err := p.ConfigureTimezone("Mars/Olympus_Mons")
+	require.Error(t, err)
+
+	var tzErr InvalidTimezoneError
+	require.True(t, errors.As(err, &tzErr))
+	require.Equal(t, "", p.GetProxyTimeSettings().Timezone)
+}
+
+// TestWithinBlockWindowOvernight ensures that a window ending earlier than it starts wraps past midnight, checking
+// the boundary minutes on either side of the start, end and midnight itself
+func TestWithinBlockWindowOvernight(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		CheckTime string
+		Want      bool
+	}{
+		{
+			Name:      "1 minute before start time",
+			CheckTime: "9:59PM",
+			Want:      false,
+		},
+		{
+			Name:      "At start time",
+			CheckTime: "10:00PM",
+			Want:      true,
+		},
+		{
+			Name:      "1 minute before midnight",
+			CheckTime: "11:59PM",
+			Want:      true,
+		},
+		{
+			Name:      "At midnight",
+			CheckTime: "12:00AM",
+			Want:      true,
+		},
+		{
+			Name:      "1 minute after midnight",
+			CheckTime: "12:01AM",
+			Want:      true,
+		},
+		{
+			Name:      "1 minute before end time",
+			CheckTime: "5:59AM",
+			Want:      true,
+		},
+		{
+			Name:      "At end time",
+			CheckTime: "6:00AM",
+			Want:      false,
+		},
+		{
+			Name:      "Middle of the day",
+			CheckTime: "1:00PM",
+			Want:      false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			p.ConfigureProxyTimeSettings("10:00PM", "6:00AM")
+
+			parsedCheckTime, parseErr := time.Parse(time.Kitchen, tc.CheckTime)
+			require.NoError(t, parseErr)
+
+			require.Equal(t, tc.Want, p.WithinBlockWindow(parsedCheckTime))
+		})
+	}
+}
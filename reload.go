@@ -0,0 +1,205 @@
+package procrastiproxy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadDebounce is how long to wait for a burst of file system events to settle before reloading. Editors commonly
+// write, truncate, rename and chmod a file as part of a single save
+var reloadDebounce = 100 * time.Millisecond
+
+// reloadConfig loads a fresh Config and applies it. If the new Config cannot be loaded or is invalid, the previous
+// configuration is left in place and the problems are logged
+func (p *Procrastiproxy) reloadConfig(path string, load func() (Config, error)) error {
+	// File changes and signals are handled separately, so make sure two reloads don't interleave
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	c, err := load()
+	if err == nil {
+		if current := p.GetPort(); current != "" && c.Port != current {
+			p.GetLogger().WithFields(logrus.Fields{
+				"Path":         path,
+				"Current Port": p.GetPort(),
+				"New Port":     c.Port,
+			}).Warn("Port changes take effect when procrastiproxy is restarted")
+			c.Port = p.GetPort()
+		}
+		if c.ProxyAddress != p.ProxyAddress {
+			p.GetLogger().WithFields(logrus.Fields{
+				"Path":                  path,
+				"Current Proxy Address": p.ProxyAddress,
+				"New Proxy Address":     c.ProxyAddress,
+			}).Warn("Proxy address changes take effect when procrastiproxy is restarted")
+			c.ProxyAddress = p.ProxyAddress
+		}
+		if c.AdminAddress != p.GetAdminAddress() {
+			p.GetLogger().WithFields(logrus.Fields{
+				"Path":                  path,
+				"Current Admin Address": p.GetAdminAddress(),
+				"New Admin Address":     c.AdminAddress,
+			}).Warn("Admin address changes take effect when procrastiproxy is restarted")
+			c.AdminAddress = p.GetAdminAddress()
+		}
+		err = p.ApplyConfig(c)
+	}
+
+	if err != nil {
+		p.GetLogger().WithFields(logrus.Fields{
+			"Path":     path,
+			"Problems": configProblems(err),
+		}).Error("Failed to reload config. Keeping the previous configuration")
+		return err
+	}
+
+	// Only a logger of our own has a level we can change. Embedders manage the level of the logger they supply
+	if logger, ok := p.GetLogger().(*logrus.Logger); ok {
+		if level, levelErr := logrus.ParseLevel(c.LogLevel); levelErr == nil {
+			logger.SetLevel(level)
+		}
+	}
+
+	p.GetLogger().WithFields(logrus.Fields{
+		"Path":                    path,
+		"Number of sites blocked": p.GetList().Length(),
+	}).Info("Reloaded config")
+	return nil
+}
+
+// configProblems flattens a validation error into one message per problem, for structured logging
+func configProblems(err error) []string {
+	var merr *multierror.Error
+	if errors.As(err, &merr) {
+		problems := make([]string, 0, len(merr.Errors))
+		for _, e := range merr.Errors {
+			problems = append(problems, e.Error())
+		}
+		return problems
+	}
+	return []string{err.Error()}
+}
+
+// reloadOnSignal reloads the config whenever a signal (normally SIGHUP) arrives on signals, until stop is closed. It
+// doesn't depend on watchConfig, so that signals keep working when the config file can't be watched
+func (p *Procrastiproxy) reloadOnSignal(path string, load func() (Config, error), signals <-chan os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case sig := <-signals:
+			p.GetLogger().WithFields(logrus.Fields{
+				"Signal": sig.String(),
+			}).Info("Received signal. Reloading config")
+			p.reloadConfig(path, load)
+		}
+	}
+}
+
+// reloadFiles returns the absolute paths of the files whose changes trigger a reload: the config file at path, unless
+// path is empty, and the block files currently configured
+func (p *Procrastiproxy) reloadFiles(path string) ([]string, error) {
+	p.configMu.RLock()
+	files := append([]string{}, p.blockFiles...)
+	p.configMu.RUnlock()
+	if path != "" {
+		files = append([]string{path}, files...)
+	}
+
+	for i, file := range files {
+		absPath, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = absPath
+	}
+	return files, nil
+}
+
+// watchConfig reloads the config whenever the config file at path, or any of the block files it lists, changes on
+// disk, until stop is closed. path may be empty, in which case only the block files are watched. load is called to
+// produce the new Config, so that flags can continue to override the file's values
+func (p *Procrastiproxy) watchConfig(path string, load func() (Config, error), stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Watch the directories rather than the files, because editors often save by replacing the file, which would
+	// otherwise end the watch. A reload may change the block files, so the files are looked up again after each one
+	watched := make(map[string]bool)
+	watchedDirs := make(map[string]bool)
+	watchFiles := func() error {
+		files, err := p.reloadFiles(path)
+		if err != nil {
+			return err
+		}
+		watched = make(map[string]bool, len(files))
+		for _, file := range files {
+			watched[file] = true
+			dir := filepath.Dir(file)
+			if watchedDirs[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				return err
+			}
+			watchedDirs[dir] = true
+		}
+		return nil
+	}
+	if err := watchFiles(); err != nil {
+		return err
+	}
+
+	p.GetLogger().WithFields(logrus.Fields{
+		"Path":                    path,
+		"Number of files watched": len(watched),
+	}).Debug("Watching config and block files for changes")
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !watched[filepath.Clean(event.Name)] {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			debounce = time.After(reloadDebounce)
+
+		case <-debounce:
+			debounce = nil
+			p.reloadConfig(path, load)
+			if err := watchFiles(); err != nil {
+				p.GetLogger().WithFields(logrus.Fields{
+					"Path":  path,
+					"Error": err,
+				}).Warn("Unable to watch block files for changes. Send SIGHUP to reload them instead")
+			}
+
+		case watchErr, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			p.GetLogger().WithFields(logrus.Fields{
+				"Path":  path,
+				"Error": watchErr,
+			}).Warn("Error watching config and block files")
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package procrastiproxy
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fileConfigLoader returns a loader for the config file at path, as RunCLI would build when no flags were passed
+func fileConfigLoader(t *testing.T, path string) func() (Config, error) {
+	fs := flag.NewFlagSet("procrastiproxy", flag.ContinueOnError)
+	flags := registerFlags(fs)
+	require.NoError(t, fs.Parse(nil))
+	return func() (Config, error) {
+		return flags.loadConfig(fs, path)
+	}
+}
+
+// waitFor polls condition until it holds, failing the test if it doesn't within a few seconds
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Condition was not met before the deadline")
+}
+
+func TestReloadConfigKeepsPreviousConfigWhenInvalid(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, "block:\n  - reddit.com\n")
+	load := fileConfigLoader(t, path)
+
+	p := NewProcrastiproxy()
+	require.NoError(t, p.reloadConfig(path, load))
+	require.True(t, p.GetList().Contains("reddit.com"))
+
+	writeTestConfig(t, filepath.Dir(path), defaultConfigFileName, "block:\n  - twitter.com\nblock_windows:\n  - 9:00AM\n")
+	require.Error(t, p.reloadConfig(path, load))
+	require.True(t, p.GetList().Contains("reddit.com"))
+	require.False(t, p.GetList().Contains("twitter.com"))
+
+	writeTestConfig(t, filepath.Dir(path), defaultConfigFileName, "block:\n  - twitter.com\n")
+	require.NoError(t, p.reloadConfig(path, load))
+	require.False(t, p.GetList().Contains("reddit.com"))
+	require.True(t, p.GetList().Contains("twitter.com"))
+}
+
+func TestReloadConfigKeepsPort(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, "port: \"9000\"\nblock:\n  - reddit.com\n")
+
+	p := NewProcrastiproxy()
+	p.SetPort("8000")
+	require.NoError(t, p.reloadConfig(path, fileConfigLoader(t, path)))
+	require.Equal(t, "8000", p.GetPort())
+}
+
+func TestWatchConfigReloadsOnChange(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), defaultConfigFileName, "block:\n  - reddit.com\n")
+	load := fileConfigLoader(t, path)
+
+	p := NewProcrastiproxy()
+	require.NoError(t, p.reloadConfig(path, load))
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- p.watchConfig(path, load, stop)
+	}()
+	// Give the watcher a moment to start before changing the file
+	time.Sleep(50 * time.Millisecond)
+
+	writeTestConfig(t, filepath.Dir(path), defaultConfigFileName, "block:\n  - twitter.com\n")
+	waitFor(t, func() bool {
+		p.configMu.RLock()
+		defer p.configMu.RUnlock()
+		return p.GetList().Contains("twitter.com")
+	})
+
+	close(stop)
+	require.NoError(t, <-done)
+}
+
+// TestReloadOnSignal ensures that SIGHUP reloads the config, even when the config file can't be watched
+func TestReloadOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestConfig(t, dir, defaultConfigFileName, "block:\n  - reddit.com\n")
+
+	reloads := make(chan struct{}, 1)
+	load := func() (Config, error) {
+		c, err := fileConfigLoader(t, path)()
+		reloads <- struct{}{}
+		return c, err
+	}
+
+	p := NewProcrastiproxy()
+	require.Error(t, p.watchConfig(filepath.Join(dir, "missing", defaultConfigFileName), load, nil))
+
+	signals := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.reloadOnSignal(path, load, signals, stop)
+		close(done)
+	}()
+
+	signals <- syscall.SIGHUP
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Config was not reloaded after SIGHUP")
+	}
+	waitFor(t, func() bool {
+		p.configMu.RLock()
+		defer p.configMu.RUnlock()
+		return p.GetList().Contains("reddit.com")
+	})
+
+	close(stop)
+	<-done
+}
+
+// TestWatchConfigReloadsBlockFiles ensures that edits to block files are picked up, whether they are listed in the
+// config file or passed without one
+func TestWatchConfigReloadsBlockFiles(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		UseConfigFile bool
+	}{
+		{Name: "Block file listed in the config file", UseConfigFile: true},
+		{Name: "Block file passed without a config file"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			// Keep the block file apart from the config file, so that its own directory has to be watched
+			blockFile := writeTestConfig(t, t.TempDir(), "blocklist.txt", "reddit.com\n")
+
+			var path string
+			var load func() (Config, error)
+			if tc.UseConfigFile {
+				path = writeTestConfig(t, t.TempDir(), defaultConfigFileName, "block_files:\n  - "+blockFile+"\n")
+				load = fileConfigLoader(t, path)
+			} else {
+				fs := flag.NewFlagSet("procrastiproxy", flag.ContinueOnError)
+				flags := registerFlags(fs)
+				require.NoError(t, fs.Parse([]string{"--block-file", blockFile}))
+				load = func() (Config, error) {
+					return flags.loadConfig(fs, "")
+				}
+			}
+
+			p := NewProcrastiproxy()
+			require.NoError(t, p.reloadConfig(path, load))
+			require.True(t, p.GetList().Contains("reddit.com"))
+
+			stop := make(chan struct{})
+			done := make(chan error, 1)
+			go func() {
+				done <- p.watchConfig(path, load, stop)
+			}()
+			// Give the watcher a moment to start before changing the file
+			time.Sleep(50 * time.Millisecond)
+
+			writeTestConfig(t, filepath.Dir(blockFile), "blocklist.txt", "twitter.com\n")
+			waitFor(t, func() bool {
+				p.configMu.RLock()
+				defer p.configMu.RUnlock()
+				return p.GetList().Contains("twitter.com") && !p.GetList().Contains("reddit.com")
+			})
+
+			close(stop)
+			require.NoError(t, <-done)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package procrastiproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Resolver looks up the addresses of hostnames. *net.Resolver satisfies it
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// WithResolveHosts sets whether hostnames that resolve into a network on the block list are refused during a block
+// window, as though they had been requested by address. It is off by default, as it adds a DNS lookup to every request
+// that no other rule decides
+func WithResolveHosts(resolve bool) Option {
+	return func(p *Procrastiproxy) {
+		p.ResolveHosts = resolve
+	}
+}
+
+// WithResolver sets the resolver used to look up hostnames when ResolveHosts is set, which is net.DefaultResolver by
+// default
+func WithResolver(resolver Resolver) Option {
+	return func(p *Procrastiproxy) {
+		p.resolver = resolver
+	}
+}
+
+func (p *Procrastiproxy) getResolver() Resolver {
+	if p.resolver == nil {
+		return net.DefaultResolver
+	}
+	return p.resolver
+}
+
+// shouldResolve reports whether the request described by d must have its host resolved before it can be forwarded:
+// only hostnames that no rule has decided are looked up, and only when the block list holds a network they could
+// resolve into. The caller must hold configMu
+func (p *Procrastiproxy) shouldResolve(d Decision) bool {
+	if !p.ResolveHosts || d.Blocked || d.Matched() {
+		return false
+	}
+	if net.ParseIP(stripPort(d.Host)) != nil {
+		return false
+	}
+	return p.GetList().hasNetworks()
+}
+
+// resolvedHostKey is the context key under which a request carries the addresses its host was checked at
+type resolvedHostKey struct{}
+
+// resolvedHost holds the addresses a host resolved to when it was checked against the block list, which are the only
+// addresses it may then be dialed at
+type resolvedHost struct {
+	host  string
+	addrs []net.IPAddr
+}
+
+// resolveRequest completes d, which describes r, by looking up its host once, refusing it if any of its addresses are
+// within a network on the block list. Otherwise, the addresses are attached to the returned request, so that the
+// connection made to forward it goes to the addresses that were checked, rather than to those of a second lookup that
+// may have changed since. It takes configMu itself, so that the lookup, which may be slow, doesn't hold up reloads
+func (p *Procrastiproxy) resolveRequest(r *http.Request, d Decision) (*http.Request, Decision, error) {
+	host := stripPort(d.Host)
+	addrs, err := p.getResolver().LookupIPAddr(r.Context(), host)
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		// The host can't be checked, so it isn't dialed either
+		return r, d, err
+	}
+
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+	for _, addr := range addrs {
+		if entry, ok := p.GetList().MatchIP(addr.IP); ok {
+			d.Blocked = true
+			d.Reason = "host resolves to " + addr.IP.String() + " on block list"
+			if d.InBlockWindow {
+				d.Reason += " during block window"
+			}
+			d.Rule = "block list: " + entry
+			return r, d, nil
+		}
+	}
+
+	p.GetLogger().WithFields(logrus.Fields{
+		"Host":      host,
+		"Addresses": addrs,
+	}).Debug("Resolved host is not on block list")
+	ctx := context.WithValue(r.Context(), resolvedHostKey{}, &resolvedHost{host: host, addrs: addrs})
+	return r.WithContext(ctx), d, nil
+}
+
+// dialResolved wraps dial so that a host that was resolved and checked against the block list by resolveRequest is
+// dialed at the addresses that were checked, in turn, rather than being looked up again. Other addresses are dialed as
+// they are
+func dialResolved(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		resolved, ok := ctx.Value(resolvedHostKey{}).(*resolvedHost)
+		if !ok {
+			return dial(ctx, network, address)
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || sanitizeHost(host) != resolved.host {
+			// The connection isn't to the checked host, e.g., it is to an upstream proxy
+			return dial(ctx, network, address)
+		}
+
+		var firstErr error
+		for _, addr := range resolved.addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(addr.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+}
+
+// getResolvedTransport returns the transport that requests carrying resolved addresses are forwarded with: a copy of
+// the proxy's transport that dials the checked addresses, and which doesn't keep connections alive, so that each
+// request is sent over a connection to the addresses checked for it. Transports other than *http.Transport can't be
+// told how to dial, so they are used as they are, and look the host up again themselves
+func (p *Procrastiproxy) getResolvedTransport() http.RoundTripper {
+	p.resolvedTransportOnce.Do(func() {
+		p.resolvedTransport = p.getTransport()
+		transport, ok := p.resolvedTransport.(*http.Transport)
+		if !ok {
+			return
+		}
+		transport = transport.Clone()
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		transport.DialContext = dialResolved(dial)
+		transport.DisableKeepAlives = true
+		p.resolvedTransport = transport
+	})
+	return p.resolvedTransport
+}
+
+// transportFor returns the transport to forward r with
+func (p *Procrastiproxy) transportFor(r *http.Request) http.RoundTripper {
+	if _, ok := r.Context().Value(resolvedHostKey{}).(*resolvedHost); ok {
+		return p.getResolvedTransport()
+	}
+	return p.getTransport()
+}
@@ -0,0 +1,293 @@
+package procrastiproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// staticResolver resolves hostnames from a fixed table, failing for any other
+type staticResolver map[string][]string
+
+func (s staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := s[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+// dialRecorder records the addresses it is asked to dial, and connects every one of them to upstream
+type dialRecorder struct {
+	upstream string
+	m        sync.Mutex
+	dialed   []string
+}
+
+func (d *dialRecorder) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.m.Lock()
+	d.dialed = append(d.dialed, address)
+	d.m.Unlock()
+	return (&net.Dialer{}).DialContext(ctx, network, d.upstream)
+}
+
+func TestResolveHosts(t *testing.T) {
+	resolver := staticResolver{
+		"www.reddit.com":  {"151.101.1.140"},
+		"reddit.map.net":  {"2a04:4e42:200::396"},
+		"example.com":     {"93.184.216.34", "93.184.216.35"},
+		"docs.reddit.com": {"151.101.1.140"},
+	}
+	now := time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		Name        string
+		Method      string
+		Target      string
+		Resolve     bool
+		Now         time.Time
+		WantStatus  int
+		WantReason  string
+		WantRule    string
+		WantLookups int
+		// WantDialed is the address the request was forwarded to, or empty if it wasn't forwarded
+		WantDialed string
+	}{
+		{
+			Name:       "Literal IPv4 address in a blocked network",
+			Target:     "http://151.101.1.140/",
+			Resolve:    true,
+			Now:        now,
+			WantStatus: http.StatusForbidden,
+			WantReason: "host on block list during block window",
+			WantRule:   "block list: 151.101.0.0/16",
+		},
+		{
+			Name:       "Literal IPv6 address in a blocked network",
+			Target:     "http://[2a04:4e42:200::396]:8080/",
+			Resolve:    true,
+			Now:        now,
+			WantStatus: http.StatusForbidden,
+			WantReason: "host on block list during block window",
+			WantRule:   "block list: 2a04:4e42::/32",
+		},
+		{
+			Name:       "Hostname is not resolved by default",
+			Target:     "http://www.reddit.com/",
+			Now:        now,
+			WantStatus: http.StatusOK,
+			WantReason: "host not on block list",
+			WantDialed: "www.reddit.com:80",
+		},
+		{
+			Name:        "Hostname resolving into a blocked network",
+			Target:      "http://www.reddit.com/",
+			Resolve:     true,
+			Now:         now,
+			WantStatus:  http.StatusForbidden,
+			WantReason:  "host resolves to 151.101.1.140 on block list during block window",
+			WantRule:    "block list: 151.101.0.0/16",
+			WantLookups: 1,
+		},
+		{
+			Name:        "Hostname resolving into a blocked IPv6 network",
+			Target:      "http://reddit.map.net/",
+			Resolve:     true,
+			Now:         now,
+			WantStatus:  http.StatusForbidden,
+			WantReason:  "host resolves to 2a04:4e42:200::396 on block list during block window",
+			WantRule:    "block list: 2a04:4e42::/32",
+			WantLookups: 1,
+		},
+		{
+			Name:        "Hostname resolving elsewhere is dialed at the checked address",
+			Target:      "http://example.com/",
+			Resolve:     true,
+			Now:         now,
+			WantStatus:  http.StatusOK,
+			WantReason:  "host not on block list",
+			WantLookups: 1,
+			WantDialed:  "93.184.216.34:80",
+		},
+		{
+			Name:        "Hostname that fails to resolve is not dialed",
+			Target:      "http://unknown.example/",
+			Resolve:     true,
+			Now:         now,
+			WantStatus:  http.StatusBadGateway,
+			WantReason:  "host not on block list",
+			WantLookups: 1,
+		},
+		{
+			Name:       "Allow list takes precedence over resolved addresses",
+			Target:     "http://docs.reddit.com/",
+			Resolve:    true,
+			Now:        now,
+			WantStatus: http.StatusOK,
+			WantReason: "host on allow list",
+			WantRule:   "allow list: docs.reddit.com",
+			WantDialed: "docs.reddit.com:80",
+		},
+		{
+			Name:       "Hostname is not resolved outside block window",
+			Target:     "http://www.reddit.com/",
+			Resolve:    true,
+			Now:        time.Date(2022, time.June, 6, 20, 0, 0, 0, time.UTC),
+			WantStatus: http.StatusOK,
+			WantReason: "outside block window",
+			WantDialed: "www.reddit.com:80",
+		},
+		{
+			Name:        "Tunnel to a hostname resolving into a blocked network",
+			Method:      http.MethodConnect,
+			Target:      "www.reddit.com:443",
+			Resolve:     true,
+			Now:         now,
+			WantStatus:  http.StatusForbidden,
+			WantReason:  "host resolves to 151.101.1.140 on block list during block window",
+			WantRule:    "block list: 151.101.0.0/16",
+			WantLookups: 1,
+		},
+		{
+			Name:        "Tunnel to a hostname resolving elsewhere is dialed at the checked address",
+			Method:      http.MethodConnect,
+			Target:      "example.com:443",
+			Resolve:     true,
+			Now:         now,
+			WantStatus:  http.StatusInternalServerError, // The recorder can't be hijacked once the tunnel is dialed
+			WantReason:  "host not on block list",
+			WantLookups: 1,
+			WantDialed:  "93.184.216.34:443",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer upstream.Close()
+			upstreamURL, err := url.Parse(upstream.URL)
+			require.NoError(t, err)
+			dialer := &dialRecorder{upstream: upstreamURL.Host}
+
+			lookups := 0
+			var decisions []Decision
+			p := NewProcrastiproxy(
+				WithClock(NewFakeClock(tc.Now)),
+				WithResolveHosts(tc.Resolve),
+				WithResolver(resolverFunc(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+					lookups++
+					return resolver.LookupIPAddr(ctx, host)
+				})),
+				WithTransport(&http.Transport{DialContext: dialer.DialContext}),
+				WithDialContext(dialer.DialContext),
+				WithAfterDecision(func(r *http.Request, d Decision) {
+					decisions = append(decisions, d)
+				}),
+			)
+			AddHostToBlockList(p.GetList(), "151.101.0.0/16", "2a04:4e42::/32")
+			p.GetAllowList().Add("docs.reddit.com")
+
+			method := tc.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			p.timeAwareHandler(w, httptest.NewRequest(method, tc.Target, nil))
+
+			require.Equal(t, tc.WantStatus, w.Code)
+			require.Len(t, decisions, 1)
+			require.Equal(t, tc.WantStatus == http.StatusForbidden, decisions[0].Blocked)
+			require.Equal(t, tc.WantReason, decisions[0].Reason)
+			require.Equal(t, tc.WantRule, decisions[0].Rule)
+			require.Equal(t, tc.WantLookups, lookups)
+			if tc.WantDialed == "" {
+				require.Empty(t, dialer.dialed)
+			} else {
+				require.Equal(t, []string{tc.WantDialed}, dialer.dialed)
+			}
+		})
+	}
+}
+
+// TestDialResolved ensures that a resolved host is dialed at each of its checked addresses in turn, and that other
+// addresses are dialed as they are
+func TestDialResolved(t *testing.T) {
+	var dialed []string
+	dial := dialResolved(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if address == "[2a04:4e42::1]:443" {
+			return nil, errors.New("network is unreachable")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+
+	ctx := context.WithValue(context.Background(), resolvedHostKey{}, &resolvedHost{
+		host:  "example.com",
+		addrs: []net.IPAddr{{IP: net.ParseIP("2a04:4e42::1")}, {IP: net.ParseIP("93.184.216.34")}},
+	})
+	conn, err := dial(ctx, "tcp", "Example.com:443")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, []string{"[2a04:4e42::1]:443", "93.184.216.34:443"}, dialed)
+
+	dialed = nil
+	conn, err = dial(ctx, "tcp", "proxy.internal:3128")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, []string{"proxy.internal:3128"}, dialed)
+
+	dialed = nil
+	conn, err = dial(context.Background(), "tcp", "example.com:443")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, []string{"example.com:443"}, dialed)
+}
+
+// TestResolveHostsSkipsLookupWithoutNetworks ensures that hostnames aren't looked up when the block list holds no
+// networks they could resolve into
+func TestResolveHostsSkipsLookupWithoutNetworks(t *testing.T) {
+	lookups := 0
+	resolver := resolverFunc(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		return nil, errors.New("no such host")
+	})
+	testHost, testURL := newTestUpstream(t)
+	p := NewProcrastiproxy(WithResolveHosts(true), WithResolver(resolver), WithClock(NewFakeClock(time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC))))
+	p.GetList().Add("reddit.com")
+
+	w := httptest.NewRecorder()
+	p.timeAwareHandler(w, httptest.NewRequest(http.MethodGet, testURL, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 0, lookups)
+
+	p.GetList().Add("151.101.0.0/16")
+	w = httptest.NewRecorder()
+	http.HandlerFunc(p.blockListAwareHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://reddit.com/", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, 0, lookups, "hosts decided by name are not looked up")
+
+	w = httptest.NewRecorder()
+	http.HandlerFunc(p.blockListAwareHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+testHost+"/", nil))
+	require.Equal(t, http.StatusBadGateway, w.Code)
+	require.Equal(t, 1, lookups, "undecided hosts are looked up once the block list holds a network")
+}
+
+type resolverFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (f resolverFunc) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return f(ctx, host)
+}
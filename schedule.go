@@ -0,0 +1,491 @@
+package procrastiproxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+)
+
+// BlockWindow is a span of wall-clock time, expressed in the time.Kitchen format (e.g., 9:00AM), during which
+// hosts on the block list are refused. An End earlier than Start wraps past midnight
+type BlockWindow struct {
+	Start string
+	End   string
+}
+
+func (bw BlockWindow) String() string {
+	return fmt.Sprintf("%s-%s", bw.Start, bw.End)
+}
+
+// WeeklySchedule maps each day of the week to the windows during which blocking applies on that day. Days that are
+// absent from the schedule are "off", and nothing is blocked on them
+type WeeklySchedule map[time.Weekday][]BlockWindow
+
+// scheduleDayNames are the days of the week in the order entries are written, starting from Monday
+var scheduleDayNames = []struct {
+	Name string
+	Day  time.Weekday
+}{
+	{"mon", time.Monday},
+	{"tue", time.Tuesday},
+	{"wed", time.Wednesday},
+	{"thu", time.Thursday},
+	{"fri", time.Friday},
+	{"sat", time.Saturday},
+	{"sun", time.Sunday},
+}
+
+// entries converts the schedule back into the entries parseSchedule accepts, one per day, so that it can be displayed
+// and parsed again. Days without windows are written as off
+func (ws WeeklySchedule) entries() []string {
+	entries := make([]string, 0, len(scheduleDayNames))
+	for _, d := range scheduleDayNames {
+		windows := ws[d.Day]
+		if len(windows) == 0 {
+			entries = append(entries, d.Name+"=off")
+			continue
+		}
+		values := make([]string, len(windows))
+		for i, bw := range windows {
+			values[i] = bw.String()
+		}
+		entries = append(entries, d.Name+"="+strings.Join(values, ","))
+	}
+	return entries
+}
+
+// clockTime is a wall-clock time of day, held as the time elapsed since midnight
+type clockTime time.Duration
+
+// parseClockTime parses a time in the time.Kitchen format, e.g., 3:04PM
+func parseClockTime(s string) (clockTime, error) {
+	tm, err := time.Parse(time.Kitchen, s)
+	if err != nil {
+		return 0, err
+	}
+	return clockTimeOf(tm), nil
+}
+
+// clockTimeOf returns the wall-clock time of day of t, in t's location
+func clockTimeOf(t time.Time) clockTime {
+	return clockTime(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond()))
+}
+
+// clockWindow is a BlockWindow with its start and end already parsed, so that matching requests against it can't fail
+type clockWindow struct {
+	BlockWindow
+	start clockTime
+	end   clockTime
+}
+
+func compileBlockWindow(bw BlockWindow) (clockWindow, error) {
+	var result *multierror.Error
+	start, startErr := parseClockTime(bw.Start)
+	if startErr != nil {
+		result = multierror.Append(result, InvalidTimeFormatError{FlagName: "block-time-start", Value: bw.Start, Underlying: startErr})
+	}
+	end, endErr := parseClockTime(bw.End)
+	if endErr != nil {
+		result = multierror.Append(result, InvalidTimeFormatError{FlagName: "block-time-end", Value: bw.End, Underlying: endErr})
+	}
+	if result.ErrorOrNil() != nil {
+		return clockWindow{}, result
+	}
+	if start == end {
+		return clockWindow{}, ZeroLengthBlockWindowError{Start: bw.Start, End: bw.End}
+	}
+	return clockWindow{BlockWindow: bw, start: start, end: end}, nil
+}
+
+// overnight reports whether the window wraps past midnight into the following day
+func (cw clockWindow) overnight() bool {
+	return cw.end < cw.start
+}
+
+// contains reports whether t falls within [start, end). An overnight window covers both the late evening and the
+// early morning
+func (cw clockWindow) contains(t clockTime) bool {
+	if cw.overnight() {
+		return t >= cw.start || t < cw.end
+	}
+	return t >= cw.start && t < cw.end
+}
+
+// blockSchedule is the parsed form of either windows that apply every day, or a WeeklySchedule. It is built once, when
+// a schedule is configured, rather than on every request
+type blockSchedule struct {
+	daily []clockWindow
+	// weekly is set when a WeeklySchedule applies, replacing the daily windows
+	weekly map[time.Weekday][]clockWindow
+}
+
+// compileBlockSchedule parses the windows of weekly if it is set, or else of windows, reporting every invalid window
+// together
+func compileBlockSchedule(windows []BlockWindow, weekly WeeklySchedule) (*blockSchedule, error) {
+	var result *multierror.Error
+	compile := func(windows []BlockWindow) []clockWindow {
+		var compiled []clockWindow
+		for _, bw := range windows {
+			cw, err := compileBlockWindow(bw)
+			if err != nil {
+				result = multierror.Append(result, InvalidBlockWindowError{Value: bw.String(), Underlying: err})
+				continue
+			}
+			compiled = append(compiled, cw)
+		}
+		return compiled
+	}
+
+	bs := &blockSchedule{}
+	if weekly != nil {
+		bs.weekly = make(map[time.Weekday][]clockWindow, len(weekly))
+		for day, windows := range weekly {
+			bs.weekly[day] = compile(windows)
+		}
+	} else {
+		bs.daily = compile(windows)
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+	return bs, nil
+}
+
+// match returns the window containing the wall-clock time of now, if any. Under a weekly schedule, the early-morning
+// portion of an overnight window belongs to the day the window started on, so Friday's 10:00PM-6:00AM window still
+// applies at 1:00AM Saturday
+func (bs *blockSchedule) match(now time.Time) (BlockWindow, bool) {
+	checkTime := clockTimeOf(now)
+
+	if bs.weekly == nil {
+		for _, cw := range bs.daily {
+			if cw.contains(checkTime) {
+				return cw.BlockWindow, true
+			}
+		}
+		return BlockWindow{}, false
+	}
+
+	for _, cw := range bs.weekly[now.Weekday()] {
+		// Only the portion of tonight's window before midnight applies today
+		if cw.contains(checkTime) && (!cw.overnight() || checkTime >= cw.start) {
+			return cw.BlockWindow, true
+		}
+	}
+
+	yesterday := (now.Weekday() + 6) % 7
+	for _, cw := range bs.weekly[yesterday] {
+		if cw.overnight() && checkTime < cw.end {
+			return cw.BlockWindow, true
+		}
+	}
+
+	return BlockWindow{}, false
+}
+
+// HostSchedule overrides the global block windows for a single host on the block list, so that, for example, news
+// sites can be blocked all workday while video sites are only blocked in the morning. Exactly one of BlockWindows,
+// which apply every day, or Schedule, which varies by weekday, should be set. The global time zone still applies
+type HostSchedule struct {
+	BlockWindows []BlockWindow
+	Schedule     WeeklySchedule
+}
+
+type InvalidHostScheduleError struct {
+	Value  string
+	Reason string
+}
+
+func (err InvalidHostScheduleError) Error() string {
+	return fmt.Sprintf("Invalid host schedule {%s} passed with flag {host-schedule}: %s. Host schedules must look like: youtube.com@9:00AM-12:00PM or youtube.com@mon-fri=9:00AM-12:00PM;sat=off", err.Value, err.Reason)
+}
+
+type InvalidBlockWindowError struct {
+	Value      string
+	Underlying error
+}
+
+func (err InvalidBlockWindowError) Error() string {
+	return fmt.Sprintf("Invalid block window {%s}. Windows must look like: 9:00AM-12:00PM. Error: %v", err.Value, err.Underlying)
+}
+
+func (err InvalidBlockWindowError) Unwrap() error {
+	return err.Underlying
+}
+
+type InvalidScheduleError struct {
+	Entry  string
+	Reason string
+}
+
+func (err InvalidScheduleError) Error() string {
+	return fmt.Sprintf("Invalid schedule entry {%s} passed with flag {schedule}: %s. Entries must look like: mon-fri=9:00AM-12:00PM,1:00PM-5:00PM;sat=10:00AM-12:00PM;sun=off", err.Entry, err.Reason)
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun":       time.Sunday,
+	"sunday":    time.Sunday,
+	"mon":       time.Monday,
+	"monday":    time.Monday,
+	"tue":       time.Tuesday,
+	"tues":      time.Tuesday,
+	"tuesday":   time.Tuesday,
+	"wed":       time.Wednesday,
+	"wednesday": time.Wednesday,
+	"thu":       time.Thursday,
+	"thur":      time.Thursday,
+	"thurs":     time.Thursday,
+	"thursday":  time.Thursday,
+	"fri":       time.Friday,
+	"friday":    time.Friday,
+	"sat":       time.Saturday,
+	"saturday":  time.Saturday,
+}
+
+// parseWeekdays converts a single day (mon) or an inclusive range of days (mon-fri) into the days it covers. Ranges
+// may wrap around the end of the week, e.g., fri-mon
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	bounds := strings.Split(strings.ToLower(strings.TrimSpace(s)), "-")
+	if len(bounds) > 2 {
+		return nil, fmt.Errorf("day range {%s} must have the form mon or mon-fri", s)
+	}
+
+	var ends []time.Weekday
+	for _, b := range bounds {
+		day, ok := weekdayNames[strings.TrimSpace(b)]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized day {%s}", b)
+		}
+		ends = append(ends, day)
+	}
+
+	days := []time.Weekday{ends[0]}
+	if len(ends) == 2 {
+		for day := ends[0]; day != ends[1]; {
+			day = (day + 1) % 7
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+// parseBlockWindow converts a string such as 9:00AM-5:00PM into a window, whose times are checked by
+// normalizeBlockWindows
+func parseBlockWindow(s string) (BlockWindow, error) {
+	bounds := strings.Split(strings.TrimSpace(s), "-")
+	if len(bounds) != 2 {
+		return BlockWindow{}, fmt.Errorf("window {%s} must have the form 9:00AM-5:00PM", s)
+	}
+	return BlockWindow{
+		Start: strings.ToUpper(strings.TrimSpace(bounds[0])),
+		End:   strings.ToUpper(strings.TrimSpace(bounds[1])),
+	}, nil
+}
+
+// parseBlockWindows validates and merges a list of windows such as 9:00AM-12:00PM, reporting every invalid window
+// together
+func parseBlockWindows(values []string) ([]BlockWindow, error) {
+	var result *multierror.Error
+	var windows []BlockWindow
+	for _, v := range values {
+		bw, err := parseBlockWindow(v)
+		if err != nil {
+			result = multierror.Append(result, InvalidBlockWindowError{Value: v, Underlying: err})
+			continue
+		}
+		windows = append(windows, bw)
+	}
+	normalized, err := normalizeBlockWindows(windows)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+	return normalized, nil
+}
+
+// normalizeBlockWindows validates windows, reporting every invalid window together, and merges those that overlap.
+// Windows are put through it however they are supplied, whether by flag, config file, Option or Configure method
+func normalizeBlockWindows(windows []BlockWindow) ([]BlockWindow, error) {
+	var result *multierror.Error
+	var compiled []clockWindow
+	for _, bw := range windows {
+		cw, err := compileBlockWindow(bw)
+		if err != nil {
+			result = multierror.Append(result, InvalidBlockWindowError{Value: bw.String(), Underlying: err})
+			continue
+		}
+		compiled = append(compiled, cw)
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+	return mergeBlockWindows(compiled), nil
+}
+
+// normalizeSchedule applies normalizeBlockWindows to the windows of each day of schedule
+func normalizeSchedule(schedule WeeklySchedule) (WeeklySchedule, error) {
+	if schedule == nil {
+		return nil, nil
+	}
+	var result *multierror.Error
+	normalized := make(WeeklySchedule, len(schedule))
+	for day, windows := range schedule {
+		merged, err := normalizeBlockWindows(windows)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		normalized[day] = merged
+	}
+	if result.ErrorOrNil() != nil {
+		return nil, result
+	}
+	return normalized, nil
+}
+
+// mergeBlockWindows combines overlapping or adjacent windows, so 9:00AM-12:00PM and 11:00AM-1:00PM become 9:00AM-1:00PM,
+// and returns the windows ordered by start time. Overnight windows are left as they are, following the daytime windows
+func mergeBlockWindows(windows []clockWindow) []BlockWindow {
+	var daytime, overnight []clockWindow
+	for _, cw := range windows {
+		if cw.overnight() {
+			overnight = append(overnight, cw)
+			continue
+		}
+		daytime = append(daytime, cw)
+	}
+
+	sort.Slice(daytime, func(i, j int) bool {
+		return daytime[i].start < daytime[j].start
+	})
+
+	var merged []clockWindow
+	for _, cw := range daytime {
+		last := len(merged) - 1
+		if last >= 0 && merged[last].end >= cw.start {
+			log.Debugf("Merging overlapping block windows %s and %s", merged[last].BlockWindow, cw.BlockWindow)
+			if cw.end > merged[last].end {
+				merged[last].End, merged[last].end = cw.End, cw.end
+			}
+			continue
+		}
+		merged = append(merged, cw)
+	}
+
+	var result []BlockWindow
+	for _, cw := range append(merged, overnight...) {
+		result = append(result, cw.BlockWindow)
+	}
+	return result
+}
+
+// parseSchedule converts the value of the --schedule flag into a WeeklySchedule. Entries are separated by semicolons
+// and applied in order, so later entries override earlier ones: mon-sun=9:00AM-5:00PM;sun=off blocks every day
+// except Sunday. A day may have several comma-separated windows: mon-fri=9:00AM-12:00PM,1:00PM-5:00PM. All invalid
+// entries are reported together
+func parseSchedule(s string) (WeeklySchedule, error) {
+	var result *multierror.Error
+	schedule := WeeklySchedule{}
+
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			result = multierror.Append(result, InvalidScheduleError{Entry: entry, Reason: "missing '=' between days and window"})
+			continue
+		}
+
+		days, dayErr := parseWeekdays(parts[0])
+		if dayErr != nil {
+			result = multierror.Append(result, InvalidScheduleError{Entry: entry, Reason: dayErr.Error()})
+			continue
+		}
+
+		if strings.EqualFold(strings.TrimSpace(parts[1]), "off") {
+			for _, day := range days {
+				delete(schedule, day)
+			}
+			continue
+		}
+
+		windows, windowErr := parseBlockWindows(strings.Split(parts[1], ","))
+		if windowErr != nil {
+			result = multierror.Append(result, InvalidScheduleError{Entry: entry, Reason: windowErr.Error()})
+			continue
+		}
+		for _, day := range days {
+			schedule[day] = windows
+		}
+	}
+
+	return schedule, result.ErrorOrNil()
+}
+
+// repeatedFlag collects the values of a flag that may be passed more than once, such as --block-window
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseHostSchedule converts a value such as youtube.com@9:00AM-12:00PM,1:00PM-2:00PM or
+// youtube.com@mon-fri=9:00AM-12:00PM;sat=off into a host and its schedule
+func parseHostSchedule(value string) (string, *HostSchedule, error) {
+	parts := strings.SplitN(value, "@", 2)
+	if len(parts) != 2 {
+		return "", nil, InvalidHostScheduleError{Value: value, Reason: "missing '@' between host and schedule"}
+	}
+
+	host := sanitizeHost(parts[0])
+	if host == "" {
+		return "", nil, InvalidHostScheduleError{Value: value, Reason: "missing host"}
+	}
+
+	// Weekly schedules name the days they apply to, while daily windows don't
+	if strings.Contains(parts[1], "=") {
+		schedule, err := parseSchedule(parts[1])
+		if err != nil {
+			return "", nil, InvalidHostScheduleError{Value: value, Reason: err.Error()}
+		}
+		return host, &HostSchedule{Schedule: schedule}, nil
+	}
+
+	windows, err := parseBlockWindows(strings.Split(parts[1], ","))
+	if err != nil {
+		return "", nil, InvalidHostScheduleError{Value: value, Reason: err.Error()}
+	}
+	return host, &HostSchedule{BlockWindows: windows}, nil
+}
+
+// parseHostSchedules adds each host passed via --host-schedule to the block list with its own schedule, reporting
+// every invalid value together
+func parseHostSchedules(values []string, list *List) error {
+	var result *multierror.Error
+	for _, v := range values {
+		host, schedule, err := parseHostSchedule(v)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if err := list.AddWithSchedule(host, schedule); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
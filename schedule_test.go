@@ -0,0 +1,445 @@
+package procrastiproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/require"
+)
+
+// scheduleTestDay returns a time on the week beginning Sunday, June 5th 2022, which makes it easy to pick weekdays
+func scheduleTestDay(day time.Weekday, hour, min int) time.Time {
+	return time.Date(2022, time.June, 5+int(day), hour, min, 0, 0, time.UTC)
+}
+
+func TestParseSchedule(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Input string
+		Want  WeeklySchedule
+	}{
+		{
+			Name:  "Weekday range with weekend overrides",
+			Input: "mon-fri=9:00AM-5:00PM;sat=10:00AM-12:00PM;sun=off",
+			Want: WeeklySchedule{
+				time.Monday:    {{Start: "9:00AM", End: "5:00PM"}},
+				time.Tuesday:   {{Start: "9:00AM", End: "5:00PM"}},
+				time.Wednesday: {{Start: "9:00AM", End: "5:00PM"}},
+				time.Thursday:  {{Start: "9:00AM", End: "5:00PM"}},
+				time.Friday:    {{Start: "9:00AM", End: "5:00PM"}},
+				time.Saturday:  {{Start: "10:00AM", End: "12:00PM"}},
+			},
+		},
+		{
+			Name:  "Later entries override earlier ones",
+			Input: "mon-sun=9:00AM-5:00PM; sat-sun=off",
+			Want: WeeklySchedule{
+				time.Monday:    {{Start: "9:00AM", End: "5:00PM"}},
+				time.Tuesday:   {{Start: "9:00AM", End: "5:00PM"}},
+				time.Wednesday: {{Start: "9:00AM", End: "5:00PM"}},
+				time.Thursday:  {{Start: "9:00AM", End: "5:00PM"}},
+				time.Friday:    {{Start: "9:00AM", End: "5:00PM"}},
+			},
+		},
+		{
+			Name:  "Ranges wrap around the end of the week and accept full names",
+			Input: "Friday-Monday=10:00pm-6:00am",
+			Want: WeeklySchedule{
+				time.Friday:   {{Start: "10:00PM", End: "6:00AM"}},
+				time.Saturday: {{Start: "10:00PM", End: "6:00AM"}},
+				time.Sunday:   {{Start: "10:00PM", End: "6:00AM"}},
+				time.Monday:   {{Start: "10:00PM", End: "6:00AM"}},
+			},
+		},
+		{
+			Name:  "Several windows per day are ordered and overlapping windows merged",
+			Input: "mon=1:00PM-5:00PM,9:00AM-12:00PM,11:30AM-12:30PM;tue=9:00AM-10:00AM,10:00AM-11:00AM",
+			Want: WeeklySchedule{
+				time.Monday:  {{Start: "9:00AM", End: "12:30PM"}, {Start: "1:00PM", End: "5:00PM"}},
+				time.Tuesday: {{Start: "9:00AM", End: "11:00AM"}},
+			},
+		},
+		{
+			Name:  "Every day off leaves an empty schedule",
+			Input: "mon-sun=off",
+			Want:  WeeklySchedule{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got, err := parseSchedule(tc.Input)
+			require.NoError(t, err)
+			require.Equal(t, tc.Want, got)
+		})
+	}
+}
+
+func TestParseScheduleReportsEveryInvalidEntry(t *testing.T) {
+	_, err := parseSchedule("mon-fri 9:00AM-5:00PM;funday=9:00AM-5:00PM;sat=9:00AM;sun=9:00AM-9:00AM")
+	require.Error(t, err)
+
+	var merr *multierror.Error
+	require.True(t, errors.As(err, &merr))
+	require.Len(t, merr.Errors, 4)
+
+	for _, e := range merr.Errors {
+		var scheduleErr InvalidScheduleError
+		require.True(t, errors.As(e, &scheduleErr), "expected InvalidScheduleError but got %T", e)
+	}
+}
+
+func TestWithinBlockWindowWithSchedule(t *testing.T) {
+	schedule, err := parseSchedule("mon-fri=9:00AM-5:00PM;sat=10:00AM-12:00PM;fri=9:00AM-5:00PM,10:00PM-6:00AM")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name string
+		Now  time.Time
+		Want bool
+	}{
+		{
+			Name: "Monday within the weekday window",
+			Now:  scheduleTestDay(time.Monday, 9, 0),
+			Want: true,
+		},
+		{
+			Name: "Monday after the weekday window",
+			Now:  scheduleTestDay(time.Monday, 17, 0),
+			Want: false,
+		},
+		{
+			Name: "Saturday within the shorter weekend window",
+			Now:  scheduleTestDay(time.Saturday, 11, 59),
+			Want: true,
+		},
+		{
+			Name: "Saturday afternoon is outside the weekend window",
+			Now:  scheduleTestDay(time.Saturday, 12, 0),
+			Want: false,
+		},
+		{
+			Name: "Sunday is off",
+			Now:  scheduleTestDay(time.Sunday, 11, 0),
+			Want: false,
+		},
+		{
+			Name: "Friday's overnight window applies late Friday",
+			Now:  scheduleTestDay(time.Friday, 23, 0),
+			Want: true,
+		},
+		{
+			Name: "Friday's overnight window carries into early Saturday",
+			Now:  scheduleTestDay(time.Saturday, 5, 59),
+			Want: true,
+		},
+		{
+			Name: "Friday's overnight window ends on Saturday morning",
+			Now:  scheduleTestDay(time.Saturday, 6, 0),
+			Want: false,
+		},
+		{
+			Name: "Early Friday is not covered by Thursday, which has no overnight window",
+			Now:  scheduleTestDay(time.Friday, 1, 0),
+			Want: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			p.ConfigureSchedule(schedule)
+
+			require.Equal(t, tc.Want, p.WithinBlockWindow(tc.Now))
+		})
+	}
+}
+
+// TestTimeAwareHandlerWithSchedule drives the weekly schedule through the proxy's injectable Now function
+func TestTimeAwareHandlerWithSchedule(t *testing.T) {
+	schedule, err := parseSchedule("mon-fri=9:00AM-5:00PM;sat=10:00AM-12:00PM;sun=off")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name string
+		Now  func() time.Time
+		Want int
+	}{
+		{
+			Name: "Request is forbidden on Wednesday afternoon",
+			Now:  func() time.Time { return scheduleTestDay(time.Wednesday, 14, 0) },
+			Want: http.StatusForbidden,
+		},
+		{
+			Name: "Request is allowed on Saturday afternoon",
+			Now:  func() time.Time { return scheduleTestDay(time.Saturday, 14, 0) },
+			Want: http.StatusOK,
+		},
+		{
+			Name: "Request is forbidden on Saturday morning",
+			Now:  func() time.Time { return scheduleTestDay(time.Saturday, 10, 30) },
+			Want: http.StatusForbidden,
+		},
+		{
+			Name: "Request is allowed all day Sunday",
+			Now:  func() time.Time { return scheduleTestDay(time.Sunday, 14, 0) },
+			Want: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			WithClock(NewFakeClock(tc.Now()))(p)
+			p.ConfigureSchedule(schedule)
+
+			testHost, testHostURL := newTestUpstream(t)
+			AddHostToBlockList(p.GetList(), testHost)
+
+			r := httptest.NewRequest(http.MethodGet, testHostURL, nil)
+			w := httptest.NewRecorder()
+
+			http.HandlerFunc(p.timeAwareHandler).ServeHTTP(w, r)
+
+			require.Equal(t, tc.Want, w.Code)
+		})
+	}
+}
+
+func TestParseBlockWindows(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Values []string
+		Want   []BlockWindow
+	}{
+		{
+			Name:   "Disjoint windows are kept in start time order",
+			Values: []string{"1:00PM-5:00PM", "9:00AM-12:00PM"},
+			Want:   []BlockWindow{{Start: "9:00AM", End: "12:00PM"}, {Start: "1:00PM", End: "5:00PM"}},
+		},
+		{
+			Name:   "Overlapping windows are merged",
+			Values: []string{"9:00AM-12:00PM", "11:00AM-1:00PM"},
+			Want:   []BlockWindow{{Start: "9:00AM", End: "1:00PM"}},
+		},
+		{
+			Name:   "A window contained by another is absorbed",
+			Values: []string{"9:00AM-5:00PM", "10:00AM-11:00AM"},
+			Want:   []BlockWindow{{Start: "9:00AM", End: "5:00PM"}},
+		},
+		{
+			Name:   "Adjacent windows are merged",
+			Values: []string{"9:00AM-12:00PM", "12:00PM-1:00PM"},
+			Want:   []BlockWindow{{Start: "9:00AM", End: "1:00PM"}},
+		},
+		{
+			Name:   "Overnight windows follow daytime windows",
+			Values: []string{"10:00PM-6:00AM", "9:00AM-12:00PM"},
+			Want:   []BlockWindow{{Start: "9:00AM", End: "12:00PM"}, {Start: "10:00PM", End: "6:00AM"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got, err := parseBlockWindows(tc.Values)
+			require.NoError(t, err)
+			require.Equal(t, tc.Want, got)
+		})
+	}
+}
+
+func TestParseBlockWindowsReportsEveryInvalidWindow(t *testing.T) {
+	_, err := parseBlockWindows([]string{"9:00AM-12:00PM", "9:00AM", "1:00PM-lunchtime", "2:00PM-2:00PM"})
+	require.Error(t, err)
+
+	var merr *multierror.Error
+	require.True(t, errors.As(err, &merr))
+	require.Len(t, merr.Errors, 3)
+
+	for _, e := range merr.Errors {
+		var windowErr InvalidBlockWindowError
+		require.True(t, errors.As(e, &windowErr), "expected InvalidBlockWindowError but got %T", e)
+	}
+}
+
+// TestMatchBlockWindowReportsMatchedWindow ensures that, with a split day, the window that applies is reported and the
+// gap between windows is not blocked
+func TestMatchBlockWindowReportsMatchedWindow(t *testing.T) {
+	windows, err := parseBlockWindows([]string{"9:00AM-12:00PM", "1:00PM-5:00PM"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name       string
+		Now        time.Time
+		WantWindow BlockWindow
+		WantMatch  bool
+	}{
+		{
+			Name:       "Morning window",
+			Now:        scheduleTestDay(time.Tuesday, 11, 59),
+			WantWindow: BlockWindow{Start: "9:00AM", End: "12:00PM"},
+			WantMatch:  true,
+		},
+		{
+			Name:      "Lunch is not blocked",
+			Now:       scheduleTestDay(time.Tuesday, 12, 30),
+			WantMatch: false,
+		},
+		{
+			Name:       "Afternoon window",
+			Now:        scheduleTestDay(time.Tuesday, 13, 0),
+			WantWindow: BlockWindow{Start: "1:00PM", End: "5:00PM"},
+			WantMatch:  true,
+		},
+		{
+			Name:      "Evening is not blocked",
+			Now:       scheduleTestDay(time.Tuesday, 17, 0),
+			WantMatch: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			p.ConfigureBlockWindows(windows)
+
+			window, ok := p.MatchBlockWindow(tc.Now)
+			require.Equal(t, tc.WantMatch, ok)
+			require.Equal(t, tc.WantWindow, window)
+			require.Equal(t, tc.WantMatch, p.WithinBlockWindow(tc.Now))
+		})
+	}
+}
+
+func TestParseHostSchedule(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Value        string
+		WantHost     string
+		WantSchedule *HostSchedule
+	}{
+		{
+			Name:     "Daily windows",
+			Value:    "YouTube.com@9:00AM-12:00PM,1:00PM-2:00PM",
+			WantHost: "youtube.com",
+			WantSchedule: &HostSchedule{
+				BlockWindows: []BlockWindow{{Start: "9:00AM", End: "12:00PM"}, {Start: "1:00PM", End: "2:00PM"}},
+			},
+		},
+		{
+			Name:     "Weekly schedule",
+			Value:    "youtube.com@sat-sun=10:00AM-12:00PM",
+			WantHost: "youtube.com",
+			WantSchedule: &HostSchedule{
+				Schedule: WeeklySchedule{
+					time.Saturday: {{Start: "10:00AM", End: "12:00PM"}},
+					time.Sunday:   {{Start: "10:00AM", End: "12:00PM"}},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			host, schedule, err := parseHostSchedule(tc.Value)
+			require.NoError(t, err)
+			require.Equal(t, tc.WantHost, host)
+			require.Equal(t, tc.WantSchedule, schedule)
+		})
+	}
+}
+
+func TestParseHostSchedulesReportsEveryInvalidValue(t *testing.T) {
+	l := NewList()
+
+	err := parseHostSchedules([]string{"youtube.com", "@9:00AM-12:00PM", "youtube.com@9:00AM", "twitch.tv@9:00AM-12:00PM"}, l)
+	require.Error(t, err)
+
+	var merr *multierror.Error
+	require.True(t, errors.As(err, &merr))
+	require.Len(t, merr.Errors, 3)
+
+	// The valid value is still added
+	require.True(t, l.Contains("twitch.tv"))
+	require.NotNil(t, l.Schedule("twitch.tv"))
+}
+
+// TestTimeAwareHandlerWithHostSchedules ensures that hosts carrying their own schedule are blocked according to it,
+// while other hosts on the block list follow the global block window
+func TestTimeAwareHandlerWithHostSchedules(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Now       time.Time
+		WantNews  int
+		WantVideo int
+	}{
+		{
+			Name:      "Both are blocked within the morning window",
+			Now:       scheduleTestDay(time.Tuesday, 10, 0),
+			WantNews:  http.StatusForbidden,
+			WantVideo: http.StatusForbidden,
+		},
+		{
+			Name:      "Only news is blocked in the afternoon",
+			Now:       scheduleTestDay(time.Tuesday, 14, 0),
+			WantNews:  http.StatusForbidden,
+			WantVideo: http.StatusOK,
+		},
+		{
+			Name:      "Video's own evening window applies outside the global window",
+			Now:       scheduleTestDay(time.Tuesday, 20, 0),
+			WantNews:  http.StatusOK,
+			WantVideo: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			WithClock(NewFakeClock(tc.Now))(p)
+			p.ConfigureProxyTimeSettings("9:00AM", "5:00PM")
+
+			newsHost, newsURL := newTestUpstream(t)
+			videoHost, videoURL := newTestUpstream(t)
+
+			AddHostToBlockList(p.GetList(), newsHost)
+			require.NoError(t, parseHostSchedules([]string{videoHost + "@9:00AM-12:00PM,7:00PM-9:00PM"}, p.GetList()))
+
+			for url, want := range map[string]int{newsURL: tc.WantNews, videoURL: tc.WantVideo} {
+				r := httptest.NewRequest(http.MethodGet, url, nil)
+				w := httptest.NewRecorder()
+
+				http.HandlerFunc(p.timeAwareHandler).ServeHTTP(w, r)
+
+				require.Equal(t, want, w.Code, "unexpected status for %s", url)
+			}
+		})
+	}
+}
+
+// TestConfiguredWindowsAreValidatedAndMerged ensures that windows supplied through the Configure methods, Options and
+// per-host schedules are checked and merged just as those passed by flag are
+func TestConfiguredWindowsAreValidatedAndMerged(t *testing.T) {
+	zeroLength := []BlockWindow{{Start: "9:00AM", End: "9:00AM"}}
+	overlapping := []BlockWindow{{Start: "11:00AM", End: "1:00PM"}, {Start: "9:00AM", End: "12:00PM"}}
+	merged := []BlockWindow{{Start: "9:00AM", End: "1:00PM"}}
+
+	p := NewProcrastiproxy()
+	err := p.ConfigureBlockWindows(zeroLength)
+	require.Error(t, err)
+	var zeroErr ZeroLengthBlockWindowError
+	require.True(t, errors.As(err, &zeroErr))
+	require.Error(t, p.ConfigureSchedule(WeeklySchedule{time.Monday: zeroLength}))
+	require.Error(t, p.GetList().AddWithSchedule("youtube.com", &HostSchedule{BlockWindows: zeroLength}))
+	require.Error(t, p.GetList().AddWithSchedule("youtube.com", &HostSchedule{Schedule: WeeklySchedule{time.Monday: zeroLength}}))
+	require.False(t, p.GetList().Contains("youtube.com"))
+
+	require.NoError(t, p.ConfigureBlockWindows(overlapping))
+	require.Equal(t, merged, p.GetProxyTimeSettings().BlockWindows)
+	require.NoError(t, p.ConfigureSchedule(WeeklySchedule{time.Monday: overlapping}))
+	require.Equal(t, WeeklySchedule{time.Monday: merged}, p.GetProxyTimeSettings().Schedule)
+	require.NoError(t, p.GetList().AddWithSchedule("youtube.com", &HostSchedule{BlockWindows: overlapping}))
+	require.Equal(t, merged, p.GetList().members["youtube.com"].schedule.BlockWindows)
+
+	p = NewProcrastiproxy(WithBlockWindows(zeroLength))
+	require.Nil(t, p.GetProxyTimeSettings().BlockWindows)
+}
@@ -0,0 +1,92 @@
+package procrastiproxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// proxyAddress returns the address the proxy listens on: ProxyAddress when set, or else every interface on Port
+func (p *Procrastiproxy) proxyAddress() string {
+	if p.listener != nil {
+		return p.listener.Addr().String()
+	}
+	if p.ProxyAddress != "" {
+		return p.ProxyAddress
+	}
+	return ":" + p.GetPort()
+}
+
+// addressesCollide reports whether listening on both TCP addresses a and b would fail, because they share a port and
+// either is every interface or both are the same one. Port 0 picks a free port, so it never collides
+func addressesCollide(a, b string) bool {
+	aHost, aPort, aErr := net.SplitHostPort(a)
+	bHost, bPort, bErr := net.SplitHostPort(b)
+	if aErr != nil || bErr != nil || aPort != bPort || aPort == "0" {
+		return false
+	}
+	return aHost == "" || bHost == "" || strings.EqualFold(aHost, bHost)
+}
+
+// separateAdmin reports whether the admin endpoints have a listener of their own
+func (p *Procrastiproxy) separateAdmin() bool {
+	return p.adminListener != nil || p.GetAdminAddress() != ""
+}
+
+// adminServerAddress returns the address the admin endpoints are served on when they have a listener of their own
+func (p *Procrastiproxy) adminServerAddress() string {
+	if p.adminListener != nil {
+		return p.adminListener.Addr().String()
+	}
+	return p.GetAdminAddress()
+}
+
+// adminRoutes returns the handler for the admin endpoints, which requires the configured admin credentials
+func (p *Procrastiproxy) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/", p.adminHandler)
+	mux.HandleFunc(apiPrefix, p.apiHandler)
+	return p.requireAdminAuth(mux)
+}
+
+// proxyRoutes returns the handler for the proxy listener. When the admin endpoints don't have their own address, they
+// are served here too, but only to requests addressed to procrastiproxy itself. Requests being proxied are never
+// routed to them, however their paths look
+func (p *Procrastiproxy) proxyRoutes() http.Handler {
+	proxy := p.Handler()
+	if p.separateAdmin() {
+		return proxy
+	}
+
+	admin := p.adminRoutes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isProxyRequest(r) {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		admin.ServeHTTP(w, r)
+	})
+}
+
+// isProxyRequest reports whether r asks procrastiproxy to reach another host, rather than being addressed to
+// procrastiproxy itself. Proxied requests are either CONNECT requests or carry an absolute URL
+func isProxyRequest(r *http.Request) bool {
+	return r.Method == http.MethodConnect || r.URL.IsAbs()
+}
+
+// newServers builds the servers for this instance, each with its own handlers, so that several instances can run in
+// one process. The admin server is nil when the admin endpoints share the proxy's listener
+func (p *Procrastiproxy) newServers() (*http.Server, *http.Server) {
+	proxyServer := &http.Server{
+		Addr:    p.proxyAddress(),
+		Handler: p.proxyRoutes(),
+	}
+	if !p.separateAdmin() {
+		return proxyServer, nil
+	}
+	adminServer := &http.Server{
+		Addr:    p.adminServerAddress(),
+		Handler: p.adminRoutes(),
+	}
+	return proxyServer, adminServer
+}
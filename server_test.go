@@ -0,0 +1,110 @@
+package procrastiproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testAdminAddress is a separate address for the admin endpoints
+const testAdminAddress = "127.0.0.1:8001"
+
+// newTestProxyServer serves the proxy's routes on a local listener, returning a client that sends its requests
+// through it
+func newTestProxyServer(t *testing.T, p *Procrastiproxy) (*httptest.Server, *http.Client) {
+	ts := httptest.NewServer(p.proxyRoutes())
+	t.Cleanup(ts.Close)
+
+	proxyURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	return ts, &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+}
+
+// newAlwaysBlockingProxy returns a proxy whose block window never ends, with the supplied hosts blocked
+func newAlwaysBlockingProxy(adminAddress string, hosts ...string) *Procrastiproxy {
+	p := NewProcrastiproxy(WithClock(NewFakeClock(time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC))))
+	p.SetAdminAddress(adminAddress)
+	AddHostToBlockList(p.GetList(), hosts...)
+	return p
+}
+
+// TestInstancesDoNotShareHandlers ensures that two proxies in one process each serve their own block list
+func TestInstancesDoNotShareHandlers(t *testing.T) {
+	testHost, testURL := newTestUpstream(t)
+
+	blocking := newAlwaysBlockingProxy(testAdminAddress, testHost)
+	permissive := newAlwaysBlockingProxy(testAdminAddress, "reddit.com")
+
+	_, blockingClient := newTestProxyServer(t, blocking)
+	_, permissiveClient := newTestProxyServer(t, permissive)
+
+	resp, err := blockingClient.Get(testURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = permissiveClient.Get(testURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// TestAdminEndpointsNotReachableThroughProxy ensures that requests being proxied never reach the admin endpoints, even
+// when their path looks like an admin command
+func TestAdminEndpointsNotReachableThroughProxy(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		AdminAddress string
+	}{
+		{Name: "Separate admin address", AdminAddress: testAdminAddress},
+		{Name: "Admin shares the proxy listener", AdminAddress: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			testHost, testURL := newTestUpstream(t)
+			p := newAlwaysBlockingProxy(tc.AdminAddress)
+			_, client := newTestProxyServer(t, p)
+
+			resp, err := client.Get(testURL + "/admin/block/" + testHost)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			// The request was forwarded to the upstream, rather than blocking it
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.False(t, p.GetList().Contains(testHost))
+		})
+	}
+}
+
+func TestAdminEndpointsOnSharedListener(t *testing.T) {
+	p := newAlwaysBlockingProxy("")
+	ts, _ := newTestProxyServer(t, p)
+
+	// Requests addressed to procrastiproxy itself, rather than proxied, reach the admin endpoints
+	resp, err := http.Get(ts.URL + "/admin/block/reddit.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, p.GetList().Contains("reddit.com"))
+}
+
+func TestNewServers(t *testing.T) {
+	p := NewProcrastiproxy()
+	p.SetPort("9000")
+	p.SetAdminAddress(testAdminAddress)
+
+	proxyServer, adminServer := p.newServers()
+	require.Equal(t, ":9000", proxyServer.Addr)
+	require.NotNil(t, adminServer)
+	require.Equal(t, testAdminAddress, adminServer.Addr)
+
+	p.ProxyAddress = "127.0.0.1:9090"
+	p.SetAdminAddress("")
+	proxyServer, adminServer = p.newServers()
+	require.Equal(t, "127.0.0.1:9090", proxyServer.Addr)
+	require.Nil(t, adminServer)
+}
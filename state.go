@@ -0,0 +1,340 @@
+package procrastiproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// State records the changes made to the block and allow lists at runtime, through the admin endpoints, so that they
+// survive a restart. Changes are kept separately from the configured lists, and are re-applied on top of them whenever
+// the configuration is loaded or reloaded
+type State struct {
+	// Blocked holds the hosts that were added to the block list at runtime
+	Blocked []string `json:"blocked" yaml:"blocked"`
+	// Unblocked holds the hosts that were removed from the block list at runtime, including hosts from the configured
+	// block list
+	Unblocked []string `json:"unblocked" yaml:"unblocked"`
+	// Allowed holds the hosts that were added to the allow list at runtime
+	Allowed []string `json:"allowed,omitempty" yaml:"allowed,omitempty"`
+	// Disallowed holds the hosts that were removed from the allow list at runtime
+	Disallowed []string `json:"disallowed,omitempty" yaml:"disallowed,omitempty"`
+}
+
+// Store persists procrastiproxy's runtime State. FileStore is used by default, but any durable store, such as an
+// embedded key-value database, can be used by implementing this interface
+type Store interface {
+	// Load returns the most recently saved State, or an empty State if none has been saved yet
+	Load() (State, error)
+	// Save durably replaces the stored State
+	Save(State) error
+}
+
+type StateFileError struct {
+	Path       string
+	Underlying error
+}
+
+func (err StateFileError) Error() string {
+	return fmt.Sprintf("Unable to use state file {%s}: %v", err.Path, err.Underlying)
+}
+
+func (err StateFileError) Unwrap() error {
+	return err.Underlying
+}
+
+// FileStore is a Store that keeps the State in a single file. Files ending in .yaml or .yml are written as YAML, and
+// everything else as JSON
+type FileStore struct {
+	Path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+// defaultStatePath returns where procrastiproxy keeps its state file when one isn't passed via --state-file:
+// $XDG_STATE_HOME/procrastiproxy/state.json, or an empty path if there is no home directory to put it in
+func defaultStatePath() string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		// Per the XDG Base Directory specification, $HOME/.local/state is used when XDG_STATE_HOME is unset
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateHome, "procrastiproxy", "state.json")
+}
+
+func (fs *FileStore) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(fs.Path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Load reads the state file. A state file that doesn't exist yet is treated as an empty State
+func (fs *FileStore) Load() (State, error) {
+	var s State
+
+	data, err := ioutil.ReadFile(fs.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, StateFileError{Path: fs.Path, Underlying: err}
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return s, nil
+	}
+
+	if fs.isYAML() {
+		err = yaml.Unmarshal(data, &s)
+	} else {
+		err = json.Unmarshal(data, &s)
+	}
+	if err != nil {
+		return s, StateFileError{Path: fs.Path, Underlying: err}
+	}
+	return s, nil
+}
+
+// Save writes the State to a temporary file alongside the state file, and then renames it into place, so that a crash
+// part of the way through leaves either the old state file or the new one, but never a truncated mixture
+func (fs *FileStore) Save(s State) error {
+	var data []byte
+	var err error
+	if fs.isYAML() {
+		data, err = yaml.Marshal(s)
+	} else {
+		data, err = json.MarshalIndent(s, "", "  ")
+	}
+	if err != nil {
+		return StateFileError{Path: fs.Path, Underlying: err}
+	}
+
+	dir := filepath.Dir(fs.Path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return StateFileError{Path: fs.Path, Underlying: err}
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(fs.Path)+".*")
+	if err != nil {
+		return StateFileError{Path: fs.Path, Underlying: err}
+	}
+	// Clean up the temporary file if anything goes wrong before it is renamed into place
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return StateFileError{Path: fs.Path, Underlying: err}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return StateFileError{Path: fs.Path, Underlying: err}
+	}
+	if err := tmp.Close(); err != nil {
+		return StateFileError{Path: fs.Path, Underlying: err}
+	}
+	if err := os.Rename(tmp.Name(), fs.Path); err != nil {
+		return StateFileError{Path: fs.Path, Underlying: err}
+	}
+	return nil
+}
+
+// block records that hosts were added to the block list at runtime
+func (s *State) block(hosts ...string) {
+	s.Unblocked = removeStrings(s.Unblocked, hosts)
+	s.Blocked = addStrings(s.Blocked, hosts)
+}
+
+// unblock records that hosts were removed from the block list at runtime
+func (s *State) unblock(hosts ...string) {
+	s.Blocked = removeStrings(s.Blocked, hosts)
+	s.Unblocked = addStrings(s.Unblocked, hosts)
+}
+
+// allow records that hosts were added to the allow list at runtime
+func (s *State) allow(hosts ...string) {
+	s.Disallowed = removeStrings(s.Disallowed, hosts)
+	s.Allowed = addStrings(s.Allowed, hosts)
+}
+
+// disallow records that hosts were removed from the allow list at runtime
+func (s *State) disallow(hosts ...string) {
+	s.Allowed = removeStrings(s.Allowed, hosts)
+	s.Disallowed = addStrings(s.Disallowed, hosts)
+}
+
+// applyTo replays the runtime changes onto the block and allow lists
+func (s State) applyTo(blockList, allowList *List) {
+	for _, host := range s.Blocked {
+		blockList.Add(host)
+	}
+	for _, host := range s.Unblocked {
+		blockList.Remove(host)
+	}
+	for _, host := range s.Allowed {
+		allowList.Add(host)
+	}
+	for _, host := range s.Disallowed {
+		allowList.Remove(host)
+	}
+}
+
+// copy returns a State that shares no slices with s
+func (s State) copy() State {
+	return State{
+		Blocked:    append([]string(nil), s.Blocked...),
+		Unblocked:  append([]string(nil), s.Unblocked...),
+		Allowed:    append([]string(nil), s.Allowed...),
+		Disallowed: append([]string(nil), s.Disallowed...),
+	}
+}
+
+// addStrings adds each of values to the sorted set ss, skipping those already present. Imports add thousands of hosts
+// at once, so the values are merged in a single sort, rather than inserted one at a time
+func addStrings(ss []string, values []string) []string {
+	ss = append(ss, values...)
+	sort.Strings(ss)
+	deduped := ss[:0]
+	for i, s := range ss {
+		if i == 0 || s != ss[i-1] {
+			deduped = append(deduped, s)
+		}
+	}
+	return deduped
+}
+
+// removeStrings removes each of values from the sorted set ss, ignoring those that aren't present
+func removeStrings(ss []string, values []string) []string {
+	remove := make(map[string]bool, len(values))
+	for _, v := range values {
+		remove[v] = true
+	}
+	kept := ss[:0]
+	for _, s := range ss {
+		if !remove[s] {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// LoadState reads the runtime changes to the block list from the proxy's Store, and applies them on top of the
+// current block list. It does nothing when the proxy has no Store
+func (p *Procrastiproxy) LoadState() error {
+	if p.Store == nil {
+		return nil
+	}
+
+	s, err := p.Store.Load()
+	if err != nil {
+		return err
+	}
+	// Stored hosts were sanitized when they were recorded, but the file may have been edited by hand since
+	for _, hosts := range [][]string{s.Blocked, s.Unblocked, s.Allowed, s.Disallowed} {
+		for i, host := range hosts {
+			hosts[i] = sanitizeHost(host)
+		}
+		sort.Strings(hosts)
+	}
+
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+
+	p.state = s
+	p.state.applyTo(p.GetList(), p.GetAllowList())
+
+	p.GetLogger().WithFields(logrus.Fields{
+		"Blocked":    len(s.Blocked),
+		"Unblocked":  len(s.Unblocked),
+		"Allowed":    len(s.Allowed),
+		"Disallowed": len(s.Disallowed),
+	}).Debug("Loaded runtime block and allow list changes")
+	return nil
+}
+
+// Block adds host to the block list, persisting the change to the proxy's Store, if it has one
+func (p *Procrastiproxy) Block(host string) error {
+	_, err := p.changeBlockList(host, true)
+	return err
+}
+
+// Unblock removes host from the block list, persisting the change to the proxy's Store, if it has one
+func (p *Procrastiproxy) Unblock(host string) error {
+	_, err := p.changeBlockList(host, false)
+	return err
+}
+
+// changeBlockList adds host to, or removes it from, the block list, reporting whether the list itself changed. The
+// change is recorded and saved either way, so that it outlives host being added to or removed from the config
+func (p *Procrastiproxy) changeBlockList(host string, block bool) (bool, error) {
+	changed, err := p.changeList(p.GetList, []string{host}, block, (*State).block, (*State).unblock)
+	return changed > 0, err
+}
+
+// changeAllowList is like changeBlockList, for the allow list
+func (p *Procrastiproxy) changeAllowList(host string, allow bool) (bool, error) {
+	changed, err := p.changeList(p.GetAllowList, []string{host}, allow, (*State).allow, (*State).disallow)
+	return changed > 0, err
+}
+
+// ImportBlockList adds every one of hosts to the block list, as Block does, but saves the change once, rather than once
+// per host. It returns the number of hosts that weren't already on the block list
+func (p *Procrastiproxy) ImportBlockList(hosts []string) (int, error) {
+	return p.changeList(p.GetList, hosts, true, (*State).block, (*State).unblock)
+}
+
+// ImportAllowList is like ImportBlockList, for the allow list
+func (p *Procrastiproxy) ImportAllowList(hosts []string) (int, error) {
+	return p.changeList(p.GetAllowList, hosts, true, (*State).allow, (*State).disallow)
+}
+
+// changeList adds hosts to, or removes them from, the list returned by list, recording the change in the State with
+// record or forget respectively, and then saving it. It returns the number of hosts whose membership changed
+func (p *Procrastiproxy) changeList(list func() *List, hosts []string, add bool, record, forget func(*State, ...string)) (int, error) {
+	keys := make([]string, len(hosts))
+	for i, host := range hosts {
+		keys[i] = listKey(host)
+	}
+
+	// stateMu is held until the change has been saved, so that concurrent changes are saved in the order they were made
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	p.configMu.Lock()
+	changed := 0
+	for _, key := range keys {
+		if list().Contains(key) != add {
+			changed++
+		}
+		if add {
+			list().Add(key)
+		} else {
+			list().Remove(key)
+		}
+	}
+	if add {
+		record(&p.state, keys...)
+	} else {
+		forget(&p.state, keys...)
+	}
+	s := p.state.copy()
+	p.configMu.Unlock()
+
+	if p.Store == nil {
+		return changed, nil
+	}
+	return changed, p.Store.Save(s)
+}
@@ -0,0 +1,145 @@
+package procrastiproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		FileName string
+	}{
+		{Name: "JSON", FileName: "state.json"},
+		{Name: "YAML", FileName: "state.yaml"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			// The state directory is created on first save
+			store := NewFileStore(filepath.Join(dir, "procrastiproxy", tc.FileName))
+
+			s, err := store.Load()
+			require.NoError(t, err)
+			require.Equal(t, State{}, s)
+
+			want := State{Blocked: []string{"reddit.com", "twitter.com"}, Unblocked: []string{"nytimes.com"}}
+			require.NoError(t, store.Save(want))
+
+			got, err := store.Load()
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+
+			// Only the state file itself is left behind, without any temporary files
+			entries, err := ioutil.ReadDir(filepath.Dir(store.Path))
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			require.Equal(t, tc.FileName, entries[0].Name())
+		})
+	}
+}
+
+func TestFileStoreRejectsCorruptFile(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), "state.json", "{\"blocked\": [")
+
+	_, err := NewFileStore(path).Load()
+	require.Error(t, err)
+	require.IsType(t, StateFileError{}, err)
+}
+
+// TestAdminChangesSurviveRestart ensures that hosts blocked and unblocked via the admin endpoints are saved, and
+// re-applied on top of the configured block list by a fresh proxy
+func TestAdminChangesSurviveRestart(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	c := DefaultConfig()
+	c.Block = []string{"reddit.com", "nytimes.com"}
+
+	p := NewProcrastiproxy()
+	p.Store = NewFileStore(statePath)
+	require.NoError(t, p.LoadState())
+	require.NoError(t, p.ApplyConfig(c))
+
+	for _, path := range []string{"/admin/block/twitter.com", "/admin/unblock/nytimes.com"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:8000%s", path), nil)
+		http.HandlerFunc(p.adminHandler).ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	restarted := NewProcrastiproxy()
+	restarted.Store = NewFileStore(statePath)
+	require.NoError(t, restarted.ApplyConfig(c))
+	require.NoError(t, restarted.LoadState())
+
+	list := restarted.GetList()
+	require.True(t, list.Contains("reddit.com"))
+	require.True(t, list.Contains("twitter.com"))
+	require.False(t, list.Contains("nytimes.com"))
+
+	// Runtime changes also survive the config being reloaded
+	require.NoError(t, restarted.ApplyConfig(c))
+	require.True(t, list.Contains("twitter.com"))
+	require.False(t, list.Contains("nytimes.com"))
+
+	// Blocking a host that was unblocked at runtime undoes the earlier change
+	require.NoError(t, restarted.Block("nytimes.com"))
+	s, err := restarted.Store.Load()
+	require.NoError(t, err)
+	require.Equal(t, []string{"nytimes.com", "twitter.com"}, s.Blocked)
+	require.Empty(t, s.Unblocked)
+}
+
+func TestAdminHandlerReportsStoreFailures(t *testing.T) {
+	// A state file inside a regular file can never be written
+	parent := writeTestConfig(t, t.TempDir(), "not-a-directory", "")
+
+	p := NewProcrastiproxy()
+	p.Store = NewFileStore(filepath.Join(parent, "state.json"))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://localhost:8000/admin/block/reddit.com", nil)
+	http.HandlerFunc(p.adminHandler).ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	// The change still applies to the running proxy
+	require.True(t, p.GetList().Contains("reddit.com"))
+}
+
+// countingStore is a Store that remembers the last State saved, and how many times Save was called
+type countingStore struct {
+	saved State
+	saves int
+}
+
+func (s *countingStore) Load() (State, error) {
+	return s.saved, nil
+}
+
+func (s *countingStore) Save(state State) error {
+	s.saved = state
+	s.saves++
+	return nil
+}
+
+// TestImportSavesOnce ensures that importing many hosts saves the State once, rather than once per host
+func TestImportSavesOnce(t *testing.T) {
+	store := &countingStore{}
+	p := NewProcrastiproxy(WithStore(store))
+	require.NoError(t, p.Unblock("youtube.com"))
+	require.NoError(t, p.Block("reddit.com"))
+	require.Equal(t, 2, store.saves)
+
+	added, err := p.ImportBlockList([]string{"twitter.com", "reddit.com", "youtube.com", "Twitter.com"})
+	require.NoError(t, err)
+	require.Equal(t, 2, added)
+	require.Equal(t, 3, store.saves)
+	require.Equal(t, []string{"reddit.com", "twitter.com", "youtube.com"}, store.saved.Blocked)
+	require.Empty(t, store.saved.Unblocked)
+}
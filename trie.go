@@ -0,0 +1,125 @@
+package procrastiproxy
+
+import "strings"
+
+// hostTrie stores domains by their labels in reverse order (com -> reddit -> old), so that checking whether a host
+// or any of its parent domains is present costs one step per label, no matter how many domains are stored
+type hostTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	// exact is set when the domain ending at this node was added directly, e.g., reddit.com, which covers both the
+	// domain itself and all of its subdomains
+	exact bool
+	// wildcard is set when the domain was added as *.reddit.com, which covers only subdomains
+	wildcard bool
+}
+
+func newHostTrie() *hostTrie {
+	return &hostTrie{root: newTrieNode()}
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: make(map[string]*trieNode)}
+}
+
+// reversedLabels splits a domain into its labels, ordered from the top-level domain down
+func reversedLabels(domain string) []string {
+	labels := strings.Split(domain, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
+
+// splitWildcard removes a leading *. from the entry, reporting whether it was present
+func splitWildcard(entry string) (string, bool) {
+	if strings.HasPrefix(entry, "*.") {
+		return strings.TrimPrefix(entry, "*."), true
+	}
+	return entry, false
+}
+
+// insert adds an entry, which is either a domain (reddit.com) or a wildcard domain (*.reddit.com)
+func (t *hostTrie) insert(entry string) {
+	domain, wildcard := splitWildcard(entry)
+	node := t.root
+	for _, label := range reversedLabels(domain) {
+		child, ok := node.children[label]
+		if !ok {
+			child = newTrieNode()
+			node.children[label] = child
+		}
+		node = child
+	}
+	if wildcard {
+		node.wildcard = true
+		return
+	}
+	node.exact = true
+}
+
+// remove deletes an entry previously passed to insert, pruning any branches left empty
+func (t *hostTrie) remove(entry string) {
+	domain, wildcard := splitWildcard(entry)
+	labels := reversedLabels(domain)
+
+	path := []*trieNode{t.root}
+	node := t.root
+	for _, label := range labels {
+		child, ok := node.children[label]
+		if !ok {
+			return
+		}
+		node = child
+		path = append(path, node)
+	}
+	if wildcard {
+		node.wildcard = false
+	} else {
+		node.exact = false
+	}
+
+	// Walk back up towards the root, removing nodes that no longer hold an entry or lead to one
+	for i := len(labels); i > 0; i-- {
+		n := path[i]
+		if n.exact || n.wildcard || len(n.children) > 0 {
+			return
+		}
+		delete(path[i-1].children, labels[i-1])
+	}
+}
+
+// match returns the most specific entry that covers host, if any. reddit.com covers reddit.com and old.reddit.com,
+// while *.reddit.com covers old.reddit.com but not reddit.com itself
+func (t *hostTrie) match(host string) (string, bool) {
+	labels := reversedLabels(host)
+
+	var matched string
+	var ok bool
+	node := t.root
+	for i, label := range labels {
+		child, found := node.children[label]
+		if !found {
+			break
+		}
+		node = child
+		if node.exact {
+			matched, ok = joinReversedLabels(labels[:i+1]), true
+		} else if node.wildcard && i < len(labels)-1 {
+			matched, ok = "*."+joinReversedLabels(labels[:i+1]), true
+		}
+	}
+	return matched, ok
+}
+
+// joinReversedLabels is the inverse of reversedLabels
+func joinReversedLabels(labels []string) string {
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[len(labels)-1-i] = label
+	}
+	return strings.Join(parts, ".")
+}
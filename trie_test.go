@@ -0,0 +1,78 @@
+package procrastiproxy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostTrieMatch(t *testing.T) {
+	trie := newHostTrie()
+	trie.insert("reddit.com")
+	trie.insert("*.youtube.com")
+	trie.insert("news.ycombinator.com")
+	trie.insert("*.ycombinator.com")
+
+	testCases := []struct {
+		Host      string
+		WantEntry string
+		WantMatch bool
+	}{
+		{Host: "reddit.com", WantEntry: "reddit.com", WantMatch: true},
+		{Host: "old.reddit.com", WantEntry: "reddit.com", WantMatch: true},
+		{Host: "www.old.reddit.com", WantEntry: "reddit.com", WantMatch: true},
+		{Host: "notreddit.com", WantMatch: false},
+		{Host: "reddit.com.evil.net", WantMatch: false},
+		{Host: "com", WantMatch: false},
+		{Host: "youtube.com", WantMatch: false},
+		{Host: "www.youtube.com", WantEntry: "*.youtube.com", WantMatch: true},
+		{Host: "m.www.youtube.com", WantEntry: "*.youtube.com", WantMatch: true},
+		{Host: "news.ycombinator.com", WantEntry: "news.ycombinator.com", WantMatch: true},
+		{Host: "jobs.ycombinator.com", WantEntry: "*.ycombinator.com", WantMatch: true},
+		{Host: "ycombinator.com", WantMatch: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Host, func(t *testing.T) {
+			entry, ok := trie.match(tc.Host)
+			require.Equal(t, tc.WantMatch, ok)
+			require.Equal(t, tc.WantEntry, entry)
+		})
+	}
+}
+
+func TestHostTrieRemovePrunesEmptyBranches(t *testing.T) {
+	trie := newHostTrie()
+	trie.insert("old.reddit.com")
+	trie.insert("reddit.com")
+	trie.insert("*.reddit.com")
+
+	trie.remove("reddit.com")
+	_, ok := trie.match("reddit.com")
+	require.False(t, ok)
+	entry, ok := trie.match("old.reddit.com")
+	require.True(t, ok)
+	require.Equal(t, "old.reddit.com", entry)
+	entry, ok = trie.match("www.reddit.com")
+	require.True(t, ok)
+	require.Equal(t, "*.reddit.com", entry)
+
+	trie.remove("*.reddit.com")
+	trie.remove("old.reddit.com")
+	require.Empty(t, trie.root.children)
+
+	// Removing entries that were never added is harmless
+	trie.remove("twitter.com")
+	trie.remove("*.twitter.com")
+}
+
+func BenchmarkListMatch(b *testing.B) {
+	l := NewList()
+	for i := 0; i < 10000; i++ {
+		l.Add(fmt.Sprintf("distraction%d.com", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Match("www.distraction9999.com:443")
+	}
+}
@@ -0,0 +1,127 @@
+package procrastiproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tunnelDialTimeout bounds how long we wait to establish the upstream half of a CONNECT tunnel
+var tunnelDialTimeout = 10 * time.Second
+
+// connectHost returns the host portion of a CONNECT request's authority (host:port), which is what
+// we need to compare against the block list
+func connectHost(r *http.Request) string {
+	authority := r.Host
+	if authority == "" {
+		authority = r.URL.Host
+	}
+	host, _, err := net.SplitHostPort(authority)
+	if err != nil {
+		// No port was supplied, so the authority is already a bare host
+		return sanitizeHost(authority)
+	}
+	return sanitizeHost(host)
+}
+
+// connectAuthority returns a CONNECT request's authority in the form block list entries are matched against: the
+// host, as returned by connectHost, along with its port. Keeping the port means that entries for a single port, such
+// as example.com:8443, refuse tunnels to it just as they refuse plain requests, while entries without a port match
+// whatever the port
+func connectAuthority(r *http.Request) string {
+	authority := r.Host
+	if authority == "" {
+		authority = r.URL.Host
+	}
+	_, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		return connectHost(r)
+	}
+	return net.JoinHostPort(connectHost(r), port)
+}
+
+// tunnelRequest handles an HTTP CONNECT request by dialing the requested authority, hijacking the client
+// connection and then copying bytes in both directions until either side hangs up. This is what allows
+// HTTPS traffic to flow through procrastiproxy. An UpstreamError is returned if the authority cannot be dialed
+func (p *Procrastiproxy) tunnelRequest(w http.ResponseWriter, r *http.Request) error {
+	authority := r.Host
+	if authority == "" {
+		authority = r.URL.Host
+	}
+
+	dialCtx, cancel := context.WithTimeout(r.Context(), tunnelDialTimeout)
+	destConn, err := dialResolved(p.getDialContext())(dialCtx, "tcp", authority)
+	cancel()
+	if err != nil {
+		upstreamErr := newUpstreamError(authority, err)
+		writeUpstreamError(w, upstreamErr)
+		return upstreamErr
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		destConn.Close()
+		http.Error(w, "Tunneling is not supported by this connection", http.StatusInternalServerError)
+		return errors.New("CONNECT tunneling is not supported by the response writer")
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		destConn.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	// Let a proxy that is shutting down wait for the tunnel to finish, or close it when it can wait no longer
+	if tracker := tunnelTrackerFrom(r.Context()); tracker != nil {
+		if !tracker.add(clientConn, destConn) {
+			clientConn.Close()
+			destConn.Close()
+			return errors.New("Procrastiproxy is shutting down, so no new tunnels are being opened")
+		}
+		defer tracker.done(clientConn, destConn)
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		clientConn.Close()
+		destConn.Close()
+		return err
+	}
+
+	p.GetLogger().WithFields(logrus.Fields{
+		"Authority": authority,
+	}).Debug("CONNECT tunnel established")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// The client may have pipelined bytes (e.g., the TLS ClientHello) that were already buffered during hijacking
+	go transfer(&wg, destConn, clientBuf.Reader)
+	go transfer(&wg, clientConn, destConn)
+	wg.Wait()
+
+	clientConn.Close()
+	destConn.Close()
+	return nil
+}
+
+// closeWriter is implemented by connections, such as *net.TCPConn, that support half-closing
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// transfer copies src into dst, then signals the end of the stream to dst so that the peer sees EOF
+func transfer(wg *sync.WaitGroup, dst net.Conn, src io.Reader) {
+	defer wg.Done()
+	io.Copy(dst, src)
+	if cw, ok := dst.(closeWriter); ok {
+		cw.CloseWrite()
+		return
+	}
+	dst.Close()
+}
@@ -0,0 +1,170 @@
+package procrastiproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTunnelingClient returns an HTTP client that trusts the supplied TLS test server and sends
+// all of its requests through the supplied proxy URL
+func newTunnelingClient(t *testing.T, ts *httptest.Server, proxyURL string) *http.Client {
+	pu, parseErr := url.Parse(proxyURL)
+	require.NoError(t, parseErr)
+
+	client := ts.Client()
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(pu)
+	client.Transport = transport
+	return client
+}
+
+func TestConnectHost(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Authority string
+		Want      string
+	}{
+		{
+			Name:      "Port is stripped from authority",
+			Authority: "reddit.com:443",
+			Want:      "reddit.com",
+		},
+		{
+			Name:      "Authority is lowercased",
+			Authority: "Reddit.COM:443",
+			Want:      "reddit.com",
+		},
+		{
+			Name:      "Authority without port is returned as-is",
+			Authority: "reddit.com",
+			Want:      "reddit.com",
+		},
+		{
+			Name:      "Internationalized authority is canonicalized",
+			Authority: "BÜCHER.de.:443",
+			Want:      "xn--bcher-kva.de",
+		},
+		{
+			Name:      "IPv6 authority has brackets and port removed",
+			Authority: "[::1]:8443",
+			Want:      "::1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodConnect, "http://"+tc.Authority, nil)
+			r.Host = tc.Authority
+			require.Equal(t, tc.Want, connectHost(r))
+		})
+	}
+}
+
+// TestConnectPortSpecificEntries ensures that block list entries for a single port refuse tunnels to that port, just
+// as they refuse plain requests, while entries without a port refuse tunnels to any port
+func TestConnectPortSpecificEntries(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Method      string
+		Authority   string
+		WantBlocked bool
+		WantHost    string
+	}{
+		{Name: "Tunnel to the blocked port", Method: http.MethodConnect, Authority: "example.com:8443", WantBlocked: true, WantHost: "example.com:8443"},
+		{Name: "Tunnel to another port", Method: http.MethodConnect, Authority: "example.com:443", WantHost: "example.com:443"},
+		{Name: "Tunnel to a subdomain on the blocked port", Method: http.MethodConnect, Authority: "www.example.com:8443", WantHost: "www.example.com:8443"},
+		{Name: "Plain request to the blocked port", Method: http.MethodGet, Authority: "example.com:8443", WantBlocked: true, WantHost: "example.com:8443"},
+		{Name: "Tunnel to a host blocked on every port", Method: http.MethodConnect, Authority: "Reddit.com:8443", WantBlocked: true, WantHost: "reddit.com:8443"},
+		{Name: "Tunnel to an IPv6 address blocked on one port", Method: http.MethodConnect, Authority: "[::1]:8443", WantBlocked: true, WantHost: "[::1]:8443"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p := NewProcrastiproxy()
+			AddHostToBlockList(p.GetList(), "example.com:8443", "reddit.com", "[::1]:8443")
+
+			r := httptest.NewRequest(tc.Method, "http://"+tc.Authority+"/", nil)
+			r.Host = tc.Authority
+			d := p.DefaultPolicy().Decide(r.Context(), r, time.Date(2022, time.June, 1, 10, 0, 0, 0, time.UTC))
+			require.Equal(t, tc.WantBlocked, d.Blocked, d.Reason)
+			require.Equal(t, tc.WantHost, d.Host)
+		})
+	}
+}
+
+// TestConnectTunneling ensures that HTTPS requests are tunneled through the proxy when permitted, and refused
+// before the tunnel is opened when the CONNECT host is blocked within the block window
+func TestConnectTunneling(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		BlockHost bool
+		Now       func() time.Time
+		WantErr   bool
+	}{
+		{
+			Name:      "Blocked host is refused within block window",
+			BlockHost: true,
+			Now: func() time.Time {
+				return time.Date(2022, time.June, 1, 10, 0, 0, 0, time.UTC)
+			},
+			WantErr: true,
+		},
+		{
+			Name:      "Blocked host is tunneled outside of block window",
+			BlockHost: true,
+			Now: func() time.Time {
+				return time.Date(2022, time.June, 1, 20, 0, 0, 0, time.UTC)
+			},
+			WantErr: false,
+		},
+		{
+			Name:      "Unblocked host is tunneled within block window",
+			BlockHost: false,
+			Now: func() time.Time {
+				return time.Date(2022, time.June, 1, 10, 0, 0, 0, time.UTC)
+			},
+			WantErr: false,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "OK")
+			}))
+			defer ts.Close()
+
+			p := NewProcrastiproxy()
+			WithClock(NewFakeClock(tc.Now()))(p)
+			p.ConfigureProxyTimeSettings("9:00AM", "5:00PM")
+			if tc.BlockHost {
+				AddHostToBlockList(p.GetList(), "127.0.0.1")
+			}
+
+			proxy := httptest.NewServer(http.HandlerFunc(p.timeAwareHandler))
+			defer proxy.Close()
+
+			client := newTunnelingClient(t, ts, proxy.URL)
+
+			res, err := client.Get(ts.URL)
+			if tc.WantErr {
+				require.Error(t, err)
+				require.True(t, strings.Contains(err.Error(), "Forbidden"), "unexpected error: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			body, readErr := ioutil.ReadAll(res.Body)
+			require.NoError(t, readErr)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, "OK", string(body))
+		})
+	}
+}
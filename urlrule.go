@@ -0,0 +1,198 @@
+package procrastiproxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// regexRulePrefix marks a URL rule whose pattern is a regular expression, rather than a glob
+const regexRulePrefix = "re:"
+
+// URLRule blocks or permits requests by their URL, so that, for example, youtube.com/shorts can be blocked while
+// youtube.com/watch stays reachable. Rules are created with ParseURLRule, which validates and compiles them up front
+type URLRule struct {
+	// Allow is set for rules that permit the requests they match, and clear for rules that block them
+	Allow bool
+	// Pattern is the glob or regular expression the rule was parsed from
+	Pattern string
+
+	// re matches the URLs the rule applies to, in the form built by ruleURL
+	re *regexp.Regexp
+}
+
+type InvalidURLRuleError struct {
+	Value  string
+	Reason string
+}
+
+func (err InvalidURLRuleError) Error() string {
+	return fmt.Sprintf("Invalid URL rule {%s} passed with flag {url-rule}: %s. Rules must look like: youtube.com/shorts*, allow youtube.com/watch?v=*, or block re:^https?://(www\\.)?youtube\\.com/shorts/", err.Value, err.Reason)
+}
+
+func (rule URLRule) String() string {
+	action := "block"
+	if rule.Allow {
+		action = "allow"
+	}
+	return action + " " + rule.Pattern
+}
+
+// ParseURLRule parses a rule of the form [allow|block] pattern. Rules block unless they start with allow.
+//
+// A pattern is either a glob of the form [scheme://]host[/path][?query], in which * matches any run of characters, or
+// a regular expression prefixed with re:, which is matched against URLs of the form scheme://host/path?query. In a glob,
+// a host without wildcards also matches its subdomains, as on the block list, and omitting the scheme, path or query
+// matches any. Ports are ignored, and hosts are matched in lower case
+func ParseURLRule(s string) (URLRule, error) {
+	var rule URLRule
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) == 2 && (strings.EqualFold(fields[0], "allow") || strings.EqualFold(fields[0], "block")):
+		rule.Allow = strings.EqualFold(fields[0], "allow")
+		rule.Pattern = fields[1]
+	case len(fields) == 1:
+		rule.Pattern = fields[0]
+	default:
+		return URLRule{}, InvalidURLRuleError{Value: s, Reason: "expected an optional action of allow or block, followed by a pattern"}
+	}
+
+	var expr string
+	if strings.HasPrefix(rule.Pattern, regexRulePrefix) {
+		expr = strings.TrimPrefix(rule.Pattern, regexRulePrefix)
+	} else {
+		var err error
+		if expr, err = globToRegexp(rule.Pattern); err != nil {
+			return URLRule{}, InvalidURLRuleError{Value: s, Reason: err.Error()}
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return URLRule{}, InvalidURLRuleError{Value: s, Reason: err.Error()}
+	}
+	rule.re = re
+	return rule, nil
+}
+
+// parseURLRules parses each rule, reporting every invalid rule together
+func parseURLRules(values []string) ([]URLRule, error) {
+	var result *multierror.Error
+	var rules []URLRule
+	for _, v := range values {
+		rule, err := ParseURLRule(v)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, result.ErrorOrNil()
+}
+
+// globToRegexp converts a glob of the form [scheme://]host[/path][?query] into an anchored regular expression matching
+// URLs built by ruleURL
+func globToRegexp(pattern string) (string, error) {
+	scheme := "https?"
+	rest := pattern
+	if i := strings.Index(rest, "://"); i >= 0 {
+		if i == 0 {
+			return "", fmt.Errorf("missing scheme before ://")
+		}
+		scheme = globPart(strings.ToLower(rest[:i]))
+		rest = rest[i+len("://"):]
+	}
+
+	query := `(\?.*)?`
+	if i := strings.Index(rest, "?"); i >= 0 {
+		query = `\?` + globPart(rest[i+1:])
+		rest = rest[:i]
+	}
+
+	path := `(/.*)?`
+	if i := strings.Index(rest, "/"); i >= 0 {
+		path = globPart(rest[i:])
+		rest = rest[:i]
+	}
+
+	// Canonicalize the host just as request hosts are, so that bücher.de matches xn--bcher-kva.de and reddit.com.
+	// matches reddit.com
+	host := canonicalHost(strings.ToLower(stripPort(rest)))
+	if host == "" {
+		return "", fmt.Errorf("missing host")
+	}
+	hostExpr := globPart(host)
+	if !strings.Contains(host, "*") {
+		// Like block list entries, a plain domain covers its subdomains
+		hostExpr = `([^/]*\.)?` + hostExpr
+	}
+
+	return "^" + scheme + "://" + hostExpr + path + query + "$", nil
+}
+
+// globPart quotes s for use in a regular expression, with each * matching any run of characters
+func globPart(s string) string {
+	parts := strings.Split(s, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, ".*")
+}
+
+// ruleURL returns the form of r's URL that URL rules are matched against: scheme://host/path?query, with the host in
+// lower case and without a port. The path is decoded and cleaned, as the upstream server will do, so that /%73horts,
+// //shorts and /./shorts can't slip past a rule for /shorts. It reports false for CONNECT requests, whose path and
+// query are hidden inside the tunnel
+func ruleURL(r *http.Request) (string, bool) {
+	if r.Method == http.MethodConnect {
+		return "", false
+	}
+
+	scheme := strings.ToLower(r.URL.Scheme)
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	u := scheme + "://" + stripPort(sanitizeHost(host)) + rulePath(r.URL.Path)
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+	return u, true
+}
+
+// rulePath cleans a decoded path, keeping any trailing slash. A ? decoded from %3F is escaped again, so that it can't
+// be mistaken for the start of the query
+func rulePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return strings.Replace(cleaned, "?", "%3F", -1)
+}
+
+// matchURLRules returns the first of rules that matches r, reporting false if there is none
+func matchURLRules(rules []URLRule, r *http.Request) (URLRule, bool) {
+	if len(rules) == 0 {
+		return URLRule{}, false
+	}
+	u, ok := ruleURL(r)
+	if !ok {
+		return URLRule{}, false
+	}
+	for _, rule := range rules {
+		if rule.re != nil && rule.re.MatchString(u) {
+			return rule, true
+		}
+	}
+	return URLRule{}, false
+}
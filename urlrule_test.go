@@ -0,0 +1,131 @@
+package procrastiproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLRuleMatches(t *testing.T) {
+	testCases := []struct {
+		Rule      string
+		URL       string
+		WantMatch bool
+	}{
+		{Rule: "youtube.com/shorts*", URL: "http://youtube.com/shorts/abc", WantMatch: true},
+		{Rule: "youtube.com/shorts*", URL: "https://www.YouTube.com:443/shorts", WantMatch: true},
+		{Rule: "youtube.com/shorts*", URL: "http://youtube.com/watch?v=abc", WantMatch: false},
+		{Rule: "youtube.com/shorts*", URL: "http://notyoutube.com/shorts", WantMatch: false},
+		{Rule: "youtube.com", URL: "http://m.youtube.com/anything?at=all", WantMatch: true},
+		{Rule: "*.youtube.com/feed", URL: "http://youtube.com/feed", WantMatch: false},
+		{Rule: "*.youtube.com/feed", URL: "http://www.youtube.com/feed", WantMatch: true},
+		{Rule: "https://news.ycombinator.com", URL: "http://news.ycombinator.com/", WantMatch: false},
+		{Rule: "youtube.com/watch?v=*", URL: "http://youtube.com/watch?v=abc", WantMatch: true},
+		{Rule: "youtube.com/watch?v=*", URL: "http://youtube.com/watch", WantMatch: false},
+		{Rule: "youtube.com/watch?*list=*", URL: "http://youtube.com/watch?v=abc&list=xyz", WantMatch: true},
+		{Rule: "example.com/a.b", URL: "http://example.com/aXb", WantMatch: false},
+		{Rule: `re:^https?://(www\.)?youtube\.com/shorts/`, URL: "http://www.youtube.com/shorts/abc", WantMatch: true},
+		{Rule: `re:^https?://(www\.)?youtube\.com/shorts/`, URL: "http://m.youtube.com/shorts/abc", WantMatch: false},
+		{Rule: "re:[?&]list=", URL: "http://youtube.com/watch?v=abc&list=xyz", WantMatch: true},
+		// The path is decoded and cleaned as the upstream server would, so alternate spellings can't bypass a rule
+		{Rule: "youtube.com/shorts*", URL: "http://youtube.com/%73horts/abc", WantMatch: true},
+		{Rule: "youtube.com/shorts*", URL: "http://youtube.com/%2Fshorts/abc", WantMatch: true},
+		{Rule: "youtube.com/shorts*", URL: "http://youtube.com//shorts/abc", WantMatch: true},
+		{Rule: "youtube.com/shorts*", URL: "http://youtube.com/./shorts/abc", WantMatch: true},
+		{Rule: "youtube.com/shorts*", URL: "http://youtube.com/feed/../shorts/abc", WantMatch: true},
+		{Rule: "youtube.com/shorts/", URL: "http://youtube.com/shorts//", WantMatch: true},
+		{Rule: "youtube.com/shorts", URL: "http://youtube.com/shorts/", WantMatch: false},
+		{Rule: "youtube.com/watch?v=*", URL: "http://youtube.com/watch%3Fv=abc", WantMatch: false},
+		// Rule hosts are canonicalized like request hosts, so any spelling of a host matches every other
+		{Rule: "bücher.de/shorts*", URL: "http://bücher.de/shorts/1", WantMatch: true},
+		{Rule: "bücher.de/shorts*", URL: "http://xn--bcher-kva.de/shorts/1", WantMatch: true},
+		{Rule: "xn--bcher-kva.de/shorts*", URL: "http://bücher.de/shorts/1", WantMatch: true},
+		{Rule: "*.bücher.de/shorts*", URL: "http://www.xn--bcher-kva.de/shorts/1", WantMatch: true},
+		{Rule: "reddit.com./r/*", URL: "http://reddit.com/r/golang", WantMatch: true},
+		{Rule: "reddit.com/r/*", URL: "http://reddit.com./r/golang", WantMatch: true},
+		{Rule: "REDDIT.com./r/*", URL: "http://old.reddit.com/r/golang", WantMatch: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Rule+" "+tc.URL, func(t *testing.T) {
+			rule, err := ParseURLRule(tc.Rule)
+			require.NoError(t, err)
+
+			_, ok := matchURLRules([]URLRule{rule}, httptest.NewRequest(http.MethodGet, tc.URL, nil))
+			require.Equal(t, tc.WantMatch, ok)
+		})
+	}
+}
+
+func TestParseURLRule(t *testing.T) {
+	rule, err := ParseURLRule("ALLOW youtube.com/watch*")
+	require.NoError(t, err)
+	require.True(t, rule.Allow)
+	require.Equal(t, "allow youtube.com/watch*", rule.String())
+
+	rule, err = ParseURLRule("youtube.com/shorts*")
+	require.NoError(t, err)
+	require.False(t, rule.Allow)
+	require.Equal(t, "block youtube.com/shorts*", rule.String())
+
+	_, err = parseURLRules([]string{"", "permit youtube.com", "re:(unclosed", "://youtube.com", "/shorts", "youtube.com/shorts*"})
+	require.Error(t, err)
+	require.Len(t, configProblems(err), 5)
+}
+
+func TestURLRulesOverrideHostLists(t *testing.T) {
+	allowWatch, err := ParseURLRule("allow youtube.com/watch*")
+	require.NoError(t, err)
+	blockShorts, err := ParseURLRule("vimeo.com/shorts*")
+	require.NoError(t, err)
+
+	p := NewProcrastiproxy(WithURLRules(allowWatch, blockShorts))
+	p.GetList().Add("youtube.com")
+	now := time.Date(2022, time.June, 6, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		Method      string
+		Target      string
+		WantBlocked bool
+		WantRule    string
+	}{
+		{Method: http.MethodGet, Target: "http://www.youtube.com/watch?v=abc", WantRule: "url rule: allow youtube.com/watch*"},
+		{Method: http.MethodGet, Target: "http://youtube.com/shorts/abc", WantBlocked: true, WantRule: "block list: youtube.com"},
+		{Method: http.MethodGet, Target: "http://vimeo.com/shorts/abc", WantBlocked: true, WantRule: "url rule: block vimeo.com/shorts*"},
+		{Method: http.MethodGet, Target: "http://vimeo.com/talks", WantRule: ""},
+		// The path of a tunneled request can't be seen, so only the host lists apply
+		{Method: http.MethodConnect, Target: "youtube.com:443", WantBlocked: true, WantRule: "block list: youtube.com"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Method+" "+tc.Target, func(t *testing.T) {
+			r := httptest.NewRequest(tc.Method, tc.Target, nil)
+			if tc.Method == http.MethodConnect {
+				r = &http.Request{Method: http.MethodConnect, Host: tc.Target, URL: r.URL}
+			}
+			d := p.DefaultPolicy().Decide(context.Background(), r, now)
+			require.Equal(t, tc.WantBlocked, d.Blocked)
+			require.Equal(t, tc.WantRule, d.Rule)
+		})
+	}
+}
+
+func TestApplyConfigURLRules(t *testing.T) {
+	c := DefaultConfig()
+	c.Block = []string{"youtube.com"}
+	c.URLRules = []string{"allow youtube.com/watch*"}
+
+	p := NewProcrastiproxy()
+	require.NoError(t, p.ApplyConfig(c))
+	require.Len(t, p.URLRules, 1)
+	require.True(t, p.URLRules[0].Allow)
+
+	c.URLRules = []string{"re:[", "youtube.com/shorts*"}
+	err := p.ApplyConfig(c)
+	require.Error(t, err)
+	require.Len(t, configProblems(err), 1)
+	// The previous rules remain in place
+	require.Equal(t, "allow youtube.com/watch*", p.URLRules[0].String())
+}